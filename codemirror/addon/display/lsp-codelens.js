@@ -0,0 +1,76 @@
+// Code lenses of the language server shown above the lines they belong to.
+// cm.setCodeLenses(lenses) takes the JSON returned by LanguageServer.CodeLenses:
+// [{range, command: {title, command, arguments}}]. Clicking a lens calls the execute
+// function of the lspCodeLens option with the lens, which should run its command through
+// workspace/executeCommand.
+
+(function(mod) {
+  if (typeof exports == "object" && typeof module == "object") // CommonJS
+    mod(require("../../lib/codemirror"));
+  else if (typeof define == "function" && define.amd) // AMD
+    define(["../../lib/codemirror"], mod);
+  else // Plain browser env
+    mod(CodeMirror);
+})(function(CodeMirror) {
+"use strict";
+
+CodeMirror.defineOption("lspCodeLens", false, function(cm, val, old) {
+  if (old && old != CodeMirror.Init && !val) cm.setCodeLenses([]);
+});
+
+function clearWidgets(cm) {
+  var widgets = cm.state.lspCodeLensWidgets || [];
+  for (var i = 0; i < widgets.length; i++) widgets[i].clear();
+  cm.state.lspCodeLensWidgets = [];
+}
+
+function lensElement(cm, lens) {
+  var title = lens.command && lens.command.title;
+  if (!lens.command || !lens.command.command) {
+    var span = document.createElement("span");
+    span.className = "CodeMirror-codelens-title";
+    span.textContent = title || "";
+    return span;
+  }
+  var link = document.createElement("a");
+  link.className = "CodeMirror-codelens-title CodeMirror-codelens-command";
+  link.textContent = title;
+  link.title = lens.command.command;
+  link.href = "javascript:void(0)";
+  CodeMirror.on(link, "mousedown", function(e) { CodeMirror.e_preventDefault(e); });
+  CodeMirror.on(link, "click", function(e) {
+    CodeMirror.e_preventDefault(e);
+    var options = cm.getOption("lspCodeLens");
+    if (options && options.execute) options.execute(cm, lens);
+  });
+  return link;
+}
+
+CodeMirror.defineExtension("setCodeLenses", function(lenses) {
+  var cm = this, byLine = {}, lines = [];
+  for (var i = 0; i < (lenses || []).length; i++) {
+    var line = lenses[i].range.start.line;
+    if (line >= cm.lineCount()) continue;
+    if (!byLine[line]) {
+      byLine[line] = [];
+      lines.push(line);
+    }
+    byLine[line].push(lenses[i]);
+  }
+
+  cm.operation(function() {
+    clearWidgets(cm);
+    for (var i = 0; i < lines.length; i++) {
+      var line = lines[i], node = document.createElement("div");
+      node.className = "CodeMirror-codelens";
+      var indent = /^\s*/.exec(cm.getLine(line))[0];
+      node.style.paddingLeft = cm.defaultCharWidth() * CodeMirror.countColumn(indent, null, cm.getOption("tabSize")) + "px";
+      for (var j = 0; j < byLine[line].length; j++) {
+        if (j > 0) node.appendChild(document.createTextNode(" | "));
+        node.appendChild(lensElement(cm, byLine[line][j]));
+      }
+      cm.state.lspCodeLensWidgets.push(cm.addLineWidget(line, node, {above: true, handleMouseEvents: true}));
+    }
+  });
+});
+});
@@ -0,0 +1,58 @@
+// Folding driven by the folding ranges of the language server.
+// cm.setFoldingRanges(ranges) takes the JSON returned by LanguageServer.FoldingRanges:
+// [{startLine, startCharacter?, endLine, endCharacter?, kind?}], kind is "imports",
+// "comment" or "region".
+
+(function(mod) {
+  if (typeof exports == "object" && typeof module == "object") // CommonJS
+    mod(require("../../lib/codemirror"));
+  else if (typeof define == "function" && define.amd) // AMD
+    define(["../../lib/codemirror"], mod);
+  else // Plain browser env
+    mod(CodeMirror);
+})(function(CodeMirror) {
+"use strict";
+
+var Pos = CodeMirror.Pos;
+
+CodeMirror.defineExtension("setFoldingRanges", function(ranges) {
+  var byLine = {};
+  for (var i = 0; i < (ranges || []).length; i++) {
+    var range = ranges[i], current = byLine[range.startLine];
+    // The outermost range wins when several start on the same line.
+    if (!current || range.endLine > current.endLine) byLine[range.startLine] = range;
+  }
+  this.state.lspFoldingRanges = {ranges: ranges || [], byLine: byLine};
+  if (this.state.foldGutter) this.refresh();
+});
+
+CodeMirror.registerHelper("fold", "lsp", function(cm, start) {
+  var state = cm.state.lspFoldingRanges;
+  if (!state) return;
+  var range = state.byLine[start.line];
+  if (!range || range.endLine >= cm.lineCount()) return;
+
+  var fromCh = range.startCharacter != null ? range.startCharacter : cm.getLine(range.startLine).length;
+  var toCh = range.endCharacter != null ? range.endCharacter : cm.getLine(range.endLine).length;
+  if (range.endLine == range.startLine && toCh <= fromCh) return;
+  return {from: Pos(range.startLine, fromCh), to: Pos(range.endLine, toCh)};
+});
+
+// foldRangesOfKind folds (or with how "unfold" unfolds) every range of the kind.
+CodeMirror.defineExtension("foldRangesOfKind", function(kind, how) {
+  var state = this.state.lspFoldingRanges;
+  if (!state) return;
+  var cm = this;
+  cm.operation(function() {
+    for (var i = 0; i < state.ranges.length; i++) {
+      if (state.ranges[i].kind != kind) continue;
+      cm.foldCode(Pos(state.ranges[i].startLine, 0), {rangeFinder: CodeMirror.fold.lsp}, how || "fold");
+    }
+  });
+});
+
+CodeMirror.commands.foldImports = function(cm) { cm.foldRangesOfKind("imports"); };
+CodeMirror.commands.unfoldImports = function(cm) { cm.foldRangesOfKind("imports", "unfold"); };
+CodeMirror.commands.foldComments = function(cm) { cm.foldRangesOfKind("comment"); };
+CodeMirror.commands.unfoldComments = function(cm) { cm.foldRangesOfKind("comment", "unfold"); };
+});
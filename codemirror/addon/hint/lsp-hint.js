@@ -0,0 +1,276 @@
+// Completion of the language server for show-hint.
+//
+// CodeMirror.hint.lsp is an async hint function. The lsp hint option takes an object with two
+// functions that ask the server:
+//   completionHints(cm, pos, context, callback): callback with the result of
+//     LanguageServer.CompletionHints, {from, to, list: [{text, displayText, className, from, to,
+//     kind, detail, tabstops, additionalTextEdits, item}], isIncomplete}, or null. context is the
+//     CompletionContext of the request, {triggerKind, triggerCharacter}.
+//   resolve(item, callback): callback with the CompletionItem resolved through
+//     completionItem/resolve, used for the documentation of the selected hint.
+// While the completion is active a complete list is filtered as the word grows, an incomplete
+// one is requested again. cm.setCompletionTriggerCharacters(characters) sets the characters of
+// the server that open the completion when typed.
+// Picking a hint applies its edit and its additional text edits (auto imports) in one
+// operation. The tab stops of snippets are selected one after another with Tab and Shift-Tab,
+// Esc leaves the snippet.
+
+(function(mod) {
+  if (typeof exports == "object" && typeof module == "object") // CommonJS
+    mod(require("../../lib/codemirror"), require("./show-hint"));
+  else if (typeof define == "function" && define.amd) // AMD
+    define(["../../lib/codemirror", "./show-hint"], mod);
+  else // Plain browser env
+    mod(CodeMirror);
+})(function(CodeMirror) {
+"use strict";
+
+var Pos = CodeMirror.Pos, cmp = CodeMirror.cmpPos;
+
+var kindIcons = {
+  text: "t", method: "m", function: "f", constructor: "c", field: "F", variable: "v", class: "C",
+  interface: "I", module: "M", property: "p", unit: "u", value: "V", enum: "E", keyword: "k",
+  snippet: "s", color: "#", file: "d", reference: "r", folder: "D", enumMember: "e", constant: "K",
+  struct: "S", event: "!", operator: "o", typeParameter: "T"
+};
+
+function toPos(position) {
+  return Pos(position.line, position.character);
+}
+
+function hintPos(position) {
+  return Pos(position.line, position.ch);
+}
+
+function render(element, data, completion) {
+  var kind = completion.lsp.kind, icon = document.createElement("span");
+  icon.className = "CodeMirror-hint-icon";
+  icon.textContent = kindIcons[kind] || " ";
+  icon.title = kind || "";
+  element.appendChild(icon);
+  element.appendChild(document.createTextNode(completion.displayText));
+  if (completion.lsp.detail) {
+    var detail = document.createElement("span");
+    detail.className = "CodeMirror-hint-detail";
+    detail.textContent = completion.lsp.detail;
+    element.appendChild(detail);
+  }
+}
+
+function apply(cm, data, completion) {
+  var hint = completion.lsp, to = completion.to, cursor = cm.getCursor();
+  // Typed ahead of the hints, the word up to the cursor is replaced.
+  if (to.line == cursor.line && cmp(to, cursor) < 0) to = cursor;
+  var edits = [{from: completion.from, to: to, text: hint.text, main: true, order: -1}];
+  var additional = hint.additionalTextEdits || [];
+  for (var i = 0; i < additional.length; i++) {
+    edits.push({from: toPos(additional[i].range.start), to: toPos(additional[i].range.end), text: additional[i].newText, order: i});
+  }
+  // From the bottom up so the positions of the remaining edits stay valid, inserts at the
+  // same position end up in their original order.
+  edits.sort(function(a, b) { return cmp(b.from, a.from) || b.order - a.order; });
+
+  var tabstops = [];
+  cm.operation(function() {
+    for (var i = 0; i < edits.length; i++) {
+      var edit = edits[i];
+      cm.replaceRange(edit.text, edit.from, edit.to, "complete");
+      if (edit.main) tabstops = markTabstops(cm, edit.from, hint.tabstops || []);
+    }
+    if (tabstops.length) startSnippet(cm, tabstops);
+  });
+}
+
+function markTabstops(cm, from, tabstops) {
+  var base = cm.indexFromPos(from), marks = [];
+  for (var i = 0; i < tabstops.length; i++) {
+    var start = cm.posFromIndex(base + tabstops[i].start), end = cm.posFromIndex(base + tabstops[i].end);
+    marks.push({index: tabstops[i].index, mark: cm.markText(start, end, {
+      className: "CodeMirror-snippet-tabstop", clearWhenEmpty: false, inclusiveLeft: true, inclusiveRight: true
+    })});
+  }
+  return marks;
+}
+
+// startSnippet takes the tab stop marks ordered by index with the final position last.
+function startSnippet(cm, tabstops) {
+  endSnippet(cm);
+  var groups = [], byIndex = {};
+  for (var i = 0; i < tabstops.length; i++) {
+    var index = tabstops[i].index;
+    if (!byIndex[index]) groups.push(byIndex[index] = []);
+    byIndex[index].push(tabstops[i].mark);
+  }
+  var snippet = cm.state.lspSnippet = {groups: groups, current: -1, keyMap: {
+    Tab: function(cm) { moveSnippet(cm, 1); },
+    "Shift-Tab": function(cm) { moveSnippet(cm, -1); },
+    Esc: endSnippet
+  }};
+  cm.addKeyMap(snippet.keyMap);
+  cm.on("cursorActivity", onCursorActivity);
+  moveSnippet(cm, 1);
+}
+
+function moveSnippet(cm, dir) {
+  var snippet = cm.state.lspSnippet;
+  for (var next = snippet.current + dir; next >= 0 && next < snippet.groups.length; next += dir) {
+    var ranges = [], group = snippet.groups[next];
+    for (var i = 0; i < group.length; i++) {
+      var found = group[i].find();
+      if (found) ranges.push({anchor: found.from, head: found.to});
+    }
+    if (!ranges.length) continue;
+    snippet.current = next;
+    cm.setSelections(ranges);
+    if (next == snippet.groups.length - 1) endSnippet(cm);
+    return;
+  }
+  if (dir > 0) endSnippet(cm);
+}
+
+function endSnippet(cm) {
+  var snippet = cm.state.lspSnippet;
+  if (!snippet) return;
+  cm.state.lspSnippet = null;
+  cm.removeKeyMap(snippet.keyMap);
+  cm.off("cursorActivity", onCursorActivity);
+  for (var i = 0; i < snippet.groups.length; i++) {
+    for (var j = 0; j < snippet.groups[i].length; j++) snippet.groups[i][j].clear();
+  }
+}
+
+// The snippet ends when the cursor leaves the tab stop it is on.
+function onCursorActivity(cm) {
+  var snippet = cm.state.lspSnippet, group = snippet && snippet.groups[snippet.current];
+  if (!group) return;
+  var cursor = cm.getCursor();
+  for (var i = 0; i < group.length; i++) {
+    var found = group[i].find();
+    if (found && cmp(found.from, cursor) <= 0 && cmp(cursor, found.to) <= 0) return;
+  }
+  endSnippet(cm);
+}
+
+function showDocumentation(data, lsp) {
+  var node = null;
+  function close() {
+    if (node && node.parentNode) node.parentNode.removeChild(node);
+    node = null;
+  }
+  CodeMirror.on(data, "select", function(completion, element) {
+    close();
+    var shown = node = document.createElement("div");
+    shown.className = "CodeMirror-hint-documentation";
+    function fill(item) {
+      if (node != shown) return;
+      var text = [item.detail, item.documentation && item.documentation.value].filter(Boolean).join("\n\n");
+      if (!text) return;
+      var hints = element.parentNode, box = hints.getBoundingClientRect();
+      shown.textContent = text;
+      shown.style.left = (box.right + window.pageXOffset + 4) + "px";
+      shown.style.top = (box.top + window.pageYOffset) + "px";
+      document.body.appendChild(shown);
+    }
+    if (completion.resolved || !lsp.resolve) return fill(completion.resolved || completion.lsp.item);
+    lsp.resolve(completion.lsp.item, function(item) {
+      completion.resolved = item || completion.lsp.item;
+      fill(completion.resolved);
+    });
+  });
+  CodeMirror.on(data, "update", close);
+  CodeMirror.on(data, "close", close);
+}
+
+var INVOKED = 1, TRIGGER_CHARACTER = 2, TRIGGER_FOR_INCOMPLETE_COMPLETIONS = 3;
+
+// cachedResult returns the last result of the active completion while only the word it
+// completes has been typed on.
+function cachedResult(cm, pos) {
+  var cache = cm.state.lspCompletion;
+  if (!cache || cache.from.line != pos.line || cmp(pos, cache.from) < 0) return null;
+  if (!/^[\w$\u00a1-\uffff]*$/.test(cm.getRange(cache.from, pos))) return null;
+  return cache;
+}
+
+function completionContext(cm, pos, cache) {
+  if (cache) return {triggerKind: TRIGGER_FOR_INCOMPLETE_COMPLETIONS};
+  var ch = pos.ch ? cm.getLine(pos.line).charAt(pos.ch - 1) : "";
+  if (ch && (cm.state.lspTriggerCharacters || []).indexOf(ch) >= 0) {
+    return {triggerKind: TRIGGER_CHARACTER, triggerCharacter: ch};
+  }
+  return {triggerKind: INVOKED};
+}
+
+// matches is the case-insensitive subsequence match the server filters with.
+function matches(word, text) {
+  word = word.toLowerCase();
+  text = text.toLowerCase();
+  for (var i = 0, j = 0; i < word.length; i++, j++) {
+    j = text.indexOf(word.charAt(i), j);
+    if (j < 0) return false;
+  }
+  return true;
+}
+
+function filterHints(cm, result, pos) {
+  var data = {from: result.from, to: pos, list: [], isIncomplete: false};
+  for (var i = 0; i < result.list.length; i++) {
+    var completion = result.list[i], item = completion.lsp.item;
+    if (completion.from.line == pos.line && cmp(completion.from, pos) <= 0 &&
+        !matches(cm.getRange(completion.from, pos), item.filterText || item.label)) continue;
+    data.list.push(completion);
+  }
+  return data;
+}
+
+function lspHint(cm, callback, options) {
+  var lsp = options.lsp, pos = cm.getCursor();
+  if (!lsp || !lsp.completionHints) return callback(null);
+  if (!cm.state.lspCompletionBound) {
+    cm.state.lspCompletionBound = true;
+    cm.on("endCompletion", function() { cm.state.lspCompletion = null; });
+  }
+  var cache = cachedResult(cm, pos);
+  if (cache && !cache.isIncomplete) {
+    var data = filterHints(cm, cache, pos);
+    if (!data.list.length) return callback(null);
+    showDocumentation(data, lsp);
+    return callback(data);
+  }
+
+  lsp.completionHints(cm, pos, completionContext(cm, pos, cache), function(result) {
+    if (!result) return callback(null);
+    var data = {from: hintPos(result.from), to: hintPos(result.to), list: [], isIncomplete: result.isIncomplete};
+    for (var i = 0; i < result.list.length; i++) {
+      var hint = result.list[i];
+      data.list.push({
+        text: hint.text, displayText: hint.displayText, className: hint.className,
+        from: hintPos(hint.from), to: hintPos(hint.to),
+        render: render, hint: apply, lsp: hint
+      });
+    }
+    cm.state.lspCompletion = data;
+    if (!data.list.length) return callback(null);
+    showDocumentation(data, lsp);
+    callback(data);
+  });
+}
+lspHint.async = true;
+
+function onInputRead(cm, change) {
+  var text = change.text[change.text.length - 1], ch = text.charAt(text.length - 1);
+  if (!ch || (cm.state.lspTriggerCharacters || []).indexOf(ch) < 0) return;
+  if (cm.showHint && !cm.state.completionActive) cm.showHint({completeSingle: false});
+}
+
+// Typing one of the characters opens the completion.
+CodeMirror.defineExtension("setCompletionTriggerCharacters", function(characters) {
+  this.state.lspTriggerCharacters = characters || [];
+  if (!this.state.lspTriggerBound) {
+    this.state.lspTriggerBound = true;
+    this.on("inputRead", onInputRead);
+  }
+});
+
+CodeMirror.registerHelper("hint", "lsp", lspHint);
+});
@@ -0,0 +1,163 @@
+// Diagnostics of the language server shown through the lint addon.
+// cm.setDiagnostics(diagnostics) takes the diagnostics of textDocument/publishDiagnostics for
+// the document in the editor: [{range, severity, code, codeDescription: {href}, source, message,
+// tags, relatedInformation: [{location: {uri, range}, message}]}]. Use it with
+//   lint: {getAnnotations: CodeMirror.lint.lsp, async: true, lintOnChange: false}
+// and the CodeMirror-lint-markers gutter. The marks and gutter markers carry the severity
+// (error, warning, info, hint), unnecessary and deprecated code gets the classes
+// CodeMirror-lint-mark-unnecessary and CodeMirror-lint-mark-deprecated.
+// CodeMirror.renderProblems(node, diagnostics, select) lists the diagnostics of all documents
+// with links to their locations and related information.
+
+(function(mod) {
+  if (typeof exports == "object" && typeof module == "object") // CommonJS
+    mod(require("../../lib/codemirror"), require("./lint"));
+  else if (typeof define == "function" && define.amd) // AMD
+    define(["../../lib/codemirror", "./lint"], mod);
+  else // Plain browser env
+    mod(CodeMirror);
+})(function(CodeMirror) {
+"use strict";
+
+var Pos = CodeMirror.Pos;
+var severities = {1: "error", 2: "warning", 3: "info", 4: "hint"};
+var tagClasses = {1: "CodeMirror-lint-mark-unnecessary", 2: "CodeMirror-lint-mark-deprecated"};
+
+function toPos(position) {
+  return Pos(position.line, position.character);
+}
+
+function escapeHTML(text) {
+  return String(text).replace(/[&<>"']/g, function(ch) {
+    return {"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;"}[ch];
+  });
+}
+
+function severityName(diagnostic) {
+  return severities[diagnostic.severity] || "error";
+}
+
+function fileName(uri) {
+  return uri.substring(uri.lastIndexOf("/") + 1);
+}
+
+// codeHTML is the source and code of the diagnostic, the code links to its codeDescription.
+function codeHTML(diagnostic) {
+  var html = escapeHTML(diagnostic.source || "");
+  if (diagnostic.code == null || diagnostic.code === "") return html;
+  var code = escapeHTML(diagnostic.code);
+  if (diagnostic.codeDescription && /^https?:/.test(diagnostic.codeDescription.href)) {
+    code = "<a href=\"" + escapeHTML(diagnostic.codeDescription.href) + "\" target=\"_blank\" rel=\"noopener\">" + code + "</a>";
+  }
+  return html ? html + "(" + code + ")" : code;
+}
+
+function messageHTML(diagnostic) {
+  var html = escapeHTML(diagnostic.message), code = codeHTML(diagnostic);
+  if (code) html += " <span class=\"CodeMirror-lint-source\">" + code + "</span>";
+  var related = diagnostic.relatedInformation || [];
+  for (var i = 0; i < related.length; i++) {
+    var start = related[i].location.range.start;
+    html += "\n  " + escapeHTML(fileName(related[i].location.uri)) + ":" + (start.line + 1) + ":" + (start.character + 1) +
+      ": " + escapeHTML(related[i].message);
+  }
+  return html;
+}
+
+function annotation(cm, diagnostic) {
+  var from = toPos(diagnostic.range.start), to = toPos(diagnostic.range.end);
+  // Empty ranges are widened to a character to be visible.
+  if (CodeMirror.cmpPos(from, to) == 0) {
+    if (to.ch < cm.getLine(to.line).length) to = Pos(to.line, to.ch + 1);
+    else if (from.ch > 0) from = Pos(from.line, from.ch - 1);
+  }
+  return {from: from, to: to, severity: severityName(diagnostic), message: diagnostic.message, messageHTML: messageHTML(diagnostic)};
+}
+
+function clearTagMarks(cm) {
+  var marks = cm.state.lspDiagnosticTags || [];
+  for (var i = 0; i < marks.length; i++) marks[i].clear();
+  cm.state.lspDiagnosticTags = [];
+}
+
+function lspLint(text, callback, options, cm) {
+  var diagnostics = cm.state.lspDiagnostics || [], annotations = [];
+  for (var i = 0; i < diagnostics.length; i++) {
+    if (diagnostics[i].range.start.line >= cm.lineCount()) continue;
+    annotations.push(annotation(cm, diagnostics[i]));
+  }
+  callback(annotations);
+}
+lspLint.async = true;
+
+CodeMirror.registerHelper("lint", "lsp", lspLint);
+
+CodeMirror.defineExtension("setDiagnostics", function(diagnostics) {
+  var cm = this;
+  cm.state.lspDiagnostics = diagnostics || [];
+  cm.operation(function() {
+    clearTagMarks(cm);
+    for (var i = 0; i < cm.state.lspDiagnostics.length; i++) {
+      var diagnostic = cm.state.lspDiagnostics[i], tags = diagnostic.tags || [];
+      if (diagnostic.range.start.line >= cm.lineCount()) continue;
+      for (var j = 0; j < tags.length; j++) {
+        if (!tagClasses[tags[j]]) continue;
+        cm.state.lspDiagnosticTags.push(cm.markText(toPos(diagnostic.range.start), toPos(diagnostic.range.end), {className: tagClasses[tags[j]]}));
+      }
+    }
+  });
+  if (cm.state.lint) cm.performLint();
+});
+
+function problemLocation(uri, range, select) {
+  var link = document.createElement("a"), start = range.start;
+  link.className = "CodeMirror-problem-location";
+  link.href = "javascript:void(0)";
+  link.textContent = fileName(uri) + ":" + (start.line + 1) + ":" + (start.character + 1);
+  link.title = uri;
+  CodeMirror.on(link, "click", function(e) {
+    CodeMirror.e_preventDefault(e);
+    select(uri, range);
+  });
+  return link;
+}
+
+// renderProblems lists the diagnostics of every document, {uri: diagnostics}, in node.
+// Clicking a location calls select(uri, range).
+CodeMirror.renderProblems = function(node, diagnostics, select) {
+  var problems = [];
+  for (var uri in diagnostics) {
+    for (var i = 0; i < diagnostics[uri].length; i++) problems.push({uri: uri, diagnostic: diagnostics[uri][i]});
+  }
+  problems.sort(function(a, b) {
+    if (a.uri != b.uri) return a.uri < b.uri ? -1 : 1;
+    var severity = (a.diagnostic.severity || 1) - (b.diagnostic.severity || 1);
+    if (severity) return severity;
+    return CodeMirror.cmpPos(toPos(a.diagnostic.range.start), toPos(b.diagnostic.range.start));
+  });
+
+  node.innerHTML = "";
+  var title = node.appendChild(document.createElement("div"));
+  title.className = "CodeMirror-problems-title";
+  title.textContent = "Problems (" + problems.length + ")";
+  var list = node.appendChild(document.createElement("ul"));
+  for (var i = 0; i < problems.length; i++) {
+    var problem = problems[i], diagnostic = problem.diagnostic;
+    var item = list.appendChild(document.createElement("li"));
+    item.className = "CodeMirror-problem CodeMirror-lint-message CodeMirror-lint-message-" + severityName(diagnostic);
+    item.appendChild(problemLocation(problem.uri, diagnostic.range, select));
+    var message = item.appendChild(document.createElement("span"));
+    message.className = "CodeMirror-problem-message";
+    message.innerHTML = " " + escapeHTML(diagnostic.message) + " <span class=\"CodeMirror-lint-source\">" + codeHTML(diagnostic) + "</span>";
+    var related = diagnostic.relatedInformation || [];
+    if (!related.length) continue;
+    var relatedList = item.appendChild(document.createElement("ul"));
+    for (var j = 0; j < related.length; j++) {
+      var relatedItem = relatedList.appendChild(document.createElement("li"));
+      relatedItem.className = "CodeMirror-problem-related";
+      relatedItem.appendChild(problemLocation(related[j].location.uri, related[j].location.range, select));
+      relatedItem.appendChild(document.createTextNode(" " + related[j].message));
+    }
+  }
+};
+});
@@ -0,0 +1,184 @@
+// Structural selection and linked editing backed by the language server.
+//
+// The option lspSelection takes an object with two functions that ask the server:
+//   selectionRanges(cm, pos, callback): callback with the SelectionRange of the position,
+//     either the nested {range, parent} result or a list of ranges innermost first.
+//   linkedEditingRanges(cm, pos, callback): callback with {ranges, wordPattern?} or null.
+// The commands expandSelection and shrinkSelection walk the selection range chain, and
+// while the cursor is inside linked editing ranges edits to one of them are copied to the
+// others.
+
+(function(mod) {
+  if (typeof exports == "object" && typeof module == "object") // CommonJS
+    mod(require("../../lib/codemirror"));
+  else if (typeof define == "function" && define.amd) // AMD
+    define(["../../lib/codemirror"], mod);
+  else // Plain browser env
+    mod(CodeMirror);
+})(function(CodeMirror) {
+"use strict";
+
+var Pos = CodeMirror.Pos, cmp = CodeMirror.cmpPos;
+var LINKED_ORIGIN = "+linkedEditing";
+
+CodeMirror.defineOption("lspSelection", false, function(cm, val, old) {
+  if (old && old != CodeMirror.Init) {
+    cm.off("cursorActivity", onCursorActivity);
+    cm.off("changes", onChanges);
+    clearLinked(cm);
+    clearTimeout(cm.state.lspSelection.timeout);
+    cm.state.lspSelection = null;
+  }
+  if (val) {
+    cm.state.lspSelection = {options: val, chain: null, stack: [], last: null, linked: [], wordPattern: null, timeout: null};
+    cm.on("cursorActivity", onCursorActivity);
+    cm.on("changes", onChanges);
+  }
+});
+
+function toPos(position) {
+  return Pos(position.line, position.character);
+}
+
+// flatten turns the answer of selectionRanges into [{from, to}], innermost first.
+function flatten(result) {
+  var chain = [];
+  if (result && result.range) {
+    for (var current = result; current; current = current.parent) chain.push(current.range);
+  } else if (result) {
+    chain = result;
+  }
+  var ranges = [];
+  for (var i = 0; i < chain.length; i++) ranges.push({from: toPos(chain[i].start), to: toPos(chain[i].end)});
+  return ranges;
+}
+
+function sameSelection(a, b) {
+  return a && b && cmp(a.from, b.from) == 0 && cmp(a.to, b.to) == 0;
+}
+
+function currentSelection(cm) {
+  return {from: cm.getCursor("from"), to: cm.getCursor("to")};
+}
+
+function select(cm, state, range) {
+  state.last = {from: range.from, to: range.to};
+  cm.setSelection(range.from, range.to, {scroll: true, origin: "*lspSelection"});
+}
+
+function expand(cm, state, chain) {
+  var selection = currentSelection(cm);
+  for (var i = 0; i < chain.length; i++) {
+    var range = chain[i];
+    if (cmp(range.from, selection.from) > 0 || cmp(range.to, selection.to) < 0) continue;
+    if (sameSelection(range, selection)) continue;
+    state.stack.push(selection);
+    select(cm, state, range);
+    return;
+  }
+}
+
+CodeMirror.commands.expandSelection = function(cm) {
+  var state = cm.state.lspSelection;
+  if (!state || !state.options.selectionRanges) return CodeMirror.Pass;
+  if (state.chain && sameSelection(state.last, currentSelection(cm))) {
+    expand(cm, state, state.chain);
+    return;
+  }
+  var generation = cm.changeGeneration(), pos = cm.getCursor("head");
+  state.options.selectionRanges(cm, pos, function(result) {
+    if (cm.state.lspSelection != state || cm.changeGeneration() != generation) return;
+    state.chain = flatten(result);
+    state.stack = [];
+    expand(cm, state, state.chain);
+  });
+};
+
+CodeMirror.commands.shrinkSelection = function(cm) {
+  var state = cm.state.lspSelection;
+  if (!state || !state.stack.length || !sameSelection(state.last, currentSelection(cm))) return CodeMirror.Pass;
+  select(cm, state, state.stack.pop());
+};
+
+function clearLinked(cm) {
+  var state = cm.state.lspSelection;
+  for (var i = 0; i < state.linked.length; i++) state.linked[i].clear();
+  state.linked = [];
+  state.wordPattern = null;
+}
+
+function linkedMarkAt(state, pos) {
+  for (var i = 0; i < state.linked.length; i++) {
+    var range = state.linked[i].find();
+    if (range && cmp(range.from, pos) <= 0 && cmp(pos, range.to) <= 0) return state.linked[i];
+  }
+  return null;
+}
+
+function onCursorActivity(cm) {
+  var state = cm.state.lspSelection;
+  if (!sameSelection(state.last, currentSelection(cm))) state.chain = null;
+  if (!state.options.linkedEditingRanges) return;
+  if (cm.somethingSelected() || cm.listSelections().length > 1) return clearLinked(cm);
+  var pos = cm.getCursor();
+  if (linkedMarkAt(state, pos)) return;
+  clearLinked(cm);
+
+  clearTimeout(state.timeout);
+  state.timeout = setTimeout(function() {
+    var generation = cm.changeGeneration();
+    state.options.linkedEditingRanges(cm, pos, function(result) {
+      if (cm.state.lspSelection != state || cm.changeGeneration() != generation) return;
+      if (cmp(cm.getCursor(), pos) != 0 || !result || !result.ranges || result.ranges.length < 2) return;
+      showLinked(cm, state, result, pos);
+    });
+  }, 150);
+}
+
+function showLinked(cm, state, result, pos) {
+  var contains = false;
+  for (var i = 0; i < result.ranges.length; i++) {
+    var from = toPos(result.ranges[i].start), to = toPos(result.ranges[i].end);
+    if (cmp(from, pos) <= 0 && cmp(pos, to) <= 0) contains = true;
+  }
+  if (!contains) return;
+  clearLinked(cm);
+  cm.operation(function() {
+    for (var i = 0; i < result.ranges.length; i++) {
+      var range = result.ranges[i];
+      state.linked.push(cm.markText(toPos(range.start), toPos(range.end), {
+        className: "CodeMirror-linkedediting",
+        inclusiveLeft: true,
+        inclusiveRight: true,
+        clearWhenEmpty: false
+      }));
+    }
+  });
+  state.wordPattern = result.wordPattern ? new RegExp("^(?:" + result.wordPattern + ")$") : null;
+}
+
+// onChanges copies an edit inside one linked range to the others once the edit is done.
+function onChanges(cm, changes) {
+  var state = cm.state.lspSelection;
+  if (!state.linked.length) return;
+  var source = null;
+  for (var i = 0; i < changes.length; i++) {
+    if (changes[i].origin == LINKED_ORIGIN) return;
+    var mark = linkedMarkAt(state, changes[i].from);
+    var end = CodeMirror.changeEnd(changes[i]);
+    if (!mark || mark != linkedMarkAt(state, end) || (source && source != mark)) return clearLinked(cm);
+    source = mark;
+  }
+  if (!source) return;
+
+  var range = source.find(), text = cm.getRange(range.from, range.to);
+  if (state.wordPattern && text && !state.wordPattern.test(text)) return clearLinked(cm);
+  cm.operation(function() {
+    for (var i = 0; i < state.linked.length; i++) {
+      if (state.linked[i] == source) continue;
+      var other = state.linked[i].find();
+      if (other && cm.getRange(other.from, other.to) != text) cm.replaceRange(text, other.from, other.to, LINKED_ORIGIN);
+    }
+  });
+}
+});
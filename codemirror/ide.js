@@ -0,0 +1,345 @@
+// Connects the editor to the language server through the JSON-RPC WebSocket served by the
+// Go program on /lsp. Document changes are sent as incremental didChange notifications,
+// the results of the server (completion, hover, diagnostics, folding, code lenses,
+// selection ranges) are shown in the editor.
+
+(function() {
+"use strict";
+
+var Pos = CodeMirror.Pos;
+
+function words(str) {
+  var obj = {}, list = str.split(" ");
+  for (var i = 0; i < list.length; i++) obj[list[i]] = true;
+  return obj;
+}
+
+CodeMirror.defineMIME("text/x-go", {
+  name: "clike",
+  keywords: words("break case chan const continue default defer else fallthrough for func go goto if import " +
+                  "interface map package range return select struct switch type var"),
+  types: words("bool byte complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string " +
+               "uint uint8 uint16 uint32 uint64 uintptr any"),
+  builtin: words("append cap close complex copy delete imag len make new panic print println real recover"),
+  atoms: words("true false nil iota"),
+  blockKeywords: words("case default else for func if interface select struct switch"),
+  defKeywords: words("func type var const package"),
+  hooks: {
+    "`": function(stream) {
+      if (stream.skipTo("`")) stream.next();
+      else stream.skipToEnd();
+      return "string";
+    }
+  }
+});
+
+// LspClient speaks JSON-RPC 2.0 over a WebSocket.
+function LspClient(url) {
+  var self = this;
+  this.nextId = 1;
+  this.pending = {};
+  this.handlers = {};
+  this.queue = [];
+  this.socket = new WebSocket(url);
+  this.socket.onopen = function() {
+    for (var i = 0; i < self.queue.length; i++) self.socket.send(self.queue[i]);
+    self.queue = [];
+    self.emit("$/open", null);
+  };
+  this.socket.onmessage = function(event) {
+    var message = JSON.parse(event.data);
+    if (message.method) return self.emit(message.method, message.params);
+    var pending = self.pending[message.id];
+    if (!pending) return;
+    delete self.pending[message.id];
+    if (message.error) pending.reject(message.error);
+    else pending.resolve(message.result);
+  };
+  this.socket.onclose = function() {
+    for (var id in self.pending) self.pending[id].reject({message: "connection closed"});
+    self.pending = {};
+    self.emit("$/close", null);
+  };
+}
+
+LspClient.prototype.send = function(message) {
+  var data = JSON.stringify(message);
+  if (this.socket.readyState == WebSocket.CONNECTING) this.queue.push(data);
+  else if (this.socket.readyState == WebSocket.OPEN) this.socket.send(data);
+};
+
+LspClient.prototype.request = function(method, params) {
+  var self = this, id = this.nextId++;
+  return new Promise(function(resolve, reject) {
+    self.pending[id] = {resolve: resolve, reject: reject};
+    self.send({jsonrpc: "2.0", id: id, method: method, params: params});
+  });
+};
+
+LspClient.prototype.notify = function(method, params) {
+  this.send({jsonrpc: "2.0", method: method, params: params});
+};
+
+LspClient.prototype.on = function(method, handler) {
+  this.handlers[method] = handler;
+};
+
+LspClient.prototype.emit = function(method, params) {
+  if (this.handlers[method]) this.handlers[method](params);
+};
+
+function toPosition(pos) {
+  return {line: pos.line, character: pos.ch};
+}
+
+function toPos(position) {
+  return Pos(position.line, position.character);
+}
+
+var modes = {"go": "text/x-go", "go.mod": "text/x-go", "java": "text/x-java"};
+
+// Ide binds one editor to one document of the language server.
+function Ide(editor, client) {
+  this.editor = editor;
+  this.client = client;
+  this.uri = null;
+  this.loading = false;
+  this.changes = [];
+  this.refreshTimer = null;
+  this.documents = {};
+  this.docs = {};
+  this.diagnostics = {};
+  this.status = document.getElementById("status");
+  this.problems = document.getElementById("problems");
+
+  var self = this;
+  editor.on("change", function(cm, change) { self.onChange(change); });
+  editor.on("changes", function() { self.flushChanges(); });
+  client.on("$/open", function() { self.initialize(); });
+  client.on("$/close", function() { self.setStatus("disconnected"); });
+  client.on("$/connection", function(event) { self.setStatus("language server " + event.state); });
+  client.on("textDocument/publishDiagnostics", function(params) { self.showDiagnostics(params); });
+  client.on("workspace/codeLens/refresh", function() { self.refreshCodeLenses(); });
+  client.on("ide/documentEdit", function(event) { self.applyDocumentEdit(event); });
+  this.bindHover();
+}
+
+Ide.prototype.setStatus = function(text) {
+  if (this.status) this.status.textContent = text;
+};
+
+Ide.prototype.textDocument = function() {
+  return {uri: this.uri};
+};
+
+Ide.prototype.initialize = function() {
+  var self = this;
+  this.setStatus("connected");
+  this.client.request("initialize", {}).then(function(result) {
+    self.editor.setCompletionTriggerCharacters(result.completionTriggerCharacters);
+    var documents = result.documents || [];
+    self.documents = {};
+    self.docs = {};
+    for (var i = 0; i < documents.length; i++) {
+      var doc = documents[i];
+      self.documents[doc.uri] = doc;
+      self.client.notify("textDocument/didOpen", {textDocument: {uri: doc.uri, languageId: doc.languageId, version: doc.version, text: doc.text}});
+    }
+    if (documents.length) self.show(documents[0]);
+  }, function(err) {
+    self.setStatus("initialize failed: " + err.message);
+  });
+};
+
+// show switches the editor to an opened document, every document keeps its own CodeMirror.Doc.
+Ide.prototype.show = function(doc) {
+  this.flushChanges();
+  if (!this.docs[doc.uri]) this.docs[doc.uri] = CodeMirror.Doc(doc.text, modes[doc.languageId] || null);
+  this.uri = doc.uri;
+  this.loading = true;
+  this.editor.swapDoc(this.docs[doc.uri]);
+  this.loading = false;
+  document.title = doc.uri.substring(doc.uri.lastIndexOf("/") + 1) + " - IDE";
+  this.editor.setDiagnostics(this.diagnostics[doc.uri]);
+  this.refresh();
+};
+
+// select shows the range of a document, used by the problems panel.
+Ide.prototype.select = function(uri, range) {
+  if (uri != this.uri) {
+    if (!this.documents[uri]) return this.setStatus(uri + " is not open in the editor");
+    this.show(this.documents[uri]);
+  }
+  this.editor.setSelection(toPos(range.start), toPos(range.end));
+  this.editor.scrollIntoView(toPos(range.start), 100);
+  this.editor.focus();
+};
+
+Ide.prototype.onChange = function(change) {
+  if (this.loading || !this.uri) return;
+  this.changes.push({
+    range: {start: toPosition(change.from), end: toPosition(change.to)},
+    text: change.text.join("\n")
+  });
+};
+
+// flushChanges sends the changes of the shown document, every flush is the next version of it.
+Ide.prototype.flushChanges = function() {
+  if (!this.changes.length) return;
+  var doc = this.documents[this.uri];
+  var textDocument = {uri: this.uri, version: doc ? ++doc.version : 0};
+  this.client.notify("textDocument/didChange", {textDocument: textDocument, contentChanges: this.changes});
+  this.changes = [];
+  var self = this;
+  clearTimeout(this.refreshTimer);
+  this.refreshTimer = setTimeout(function() { self.refresh(); }, 500);
+};
+
+Ide.prototype.save = function() {
+  if (!this.uri) return;
+  this.flushChanges();
+  this.client.notify("textDocument/didSave", {textDocument: this.textDocument(), text: this.editor.getValue()});
+};
+
+// applyDocumentEdit follows a workspace edit of the server, go.mod tidy or a code action, in
+// the document it changed. The changes are already on the server and are not sent back. They
+// are for the version before event.version, an editor at another version or with changes not
+// sent yet has text the server does not have and takes the text of the edit instead, the
+// server drops the changes it made to the old text.
+Ide.prototype.applyDocumentEdit = function(event) {
+  if (event.closed) {
+    delete this.documents[event.uri];
+    delete this.docs[event.uri];
+    if (event.uri == this.uri) this.uri = null;
+    return;
+  }
+  var doc = this.documents[event.uri];
+  if (!doc) doc = this.documents[event.uri] = {uri: event.uri, languageId: event.languageId, text: "", version: 0};
+  if (!this.docs[doc.uri]) this.docs[doc.uri] = CodeMirror.Doc(doc.text, modes[doc.languageId] || null);
+  var cmDoc = this.docs[doc.uri], changes = event.changes || [];
+  var unsent = doc.uri == this.uri && this.changes.length > 0;
+  this.loading = true;
+  if (unsent || doc.version != event.version - 1) {
+    if (doc.uri == this.uri) this.changes = [];
+    cmDoc.setValue(event.text || "");
+  } else {
+    for (var i = 0; i < changes.length; i++) {
+      if (!changes[i].range) cmDoc.setValue(changes[i].text);
+      else cmDoc.replaceRange(changes[i].text, toPos(changes[i].range.start), toPos(changes[i].range.end), "lsp");
+    }
+  }
+  this.loading = false;
+  doc.version = event.version;
+  if (!this.uri) this.show(doc);
+  else if (doc.uri == this.uri) this.refresh();
+};
+
+Ide.prototype.refresh = function() {
+  this.refreshFolding();
+  this.refreshCodeLenses();
+};
+
+Ide.prototype.refreshFolding = function() {
+  var self = this, uri = this.uri;
+  this.client.request("textDocument/foldingRange", {textDocument: this.textDocument()}).then(function(ranges) {
+    if (uri == self.uri) self.editor.setFoldingRanges(ranges || []);
+  }, function() {});
+};
+
+Ide.prototype.refreshCodeLenses = function() {
+  var self = this, uri = this.uri;
+  if (!uri) return;
+  this.client.request("textDocument/codeLens", {textDocument: this.textDocument()}).then(function(lenses) {
+    if (uri == self.uri) self.editor.setCodeLenses(lenses || []);
+  }, function() {});
+};
+
+Ide.prototype.executeCodeLens = function(lens) {
+  var self = this;
+  this.flushChanges();
+  this.setStatus("running " + lens.command.title);
+  this.client.request("workspace/executeCommand", {command: lens.command.command, arguments: lens.command.arguments}).then(function() {
+    self.setStatus(lens.command.title + " done");
+  }, function(err) {
+    self.setStatus(lens.command.title + " failed: " + err.message);
+  });
+};
+
+Ide.prototype.positionParams = function(pos) {
+  return {textDocument: this.textDocument(), position: toPosition(pos)};
+};
+
+// completionHints asks the server for the completion hints at the position, see lsp-hint.js.
+Ide.prototype.completionHints = function(cm, pos, context, callback) {
+  var params = this.positionParams(pos);
+  params.context = context;
+  this.flushChanges();
+  this.client.request("ide/completionHints", params).then(callback, function() {
+    callback(null);
+  });
+};
+
+Ide.prototype.resolveCompletion = function(item, callback) {
+  this.client.request("completionItem/resolve", item).then(callback, function() {
+    callback(null);
+  });
+};
+
+Ide.prototype.selectionRanges = function(cm, pos, callback) {
+  this.client.request("textDocument/selectionRange", {textDocument: this.textDocument(), positions: [toPosition(pos)]}).then(function(result) {
+    callback(result && result[0]);
+  }, function() {
+    callback(null);
+  });
+};
+
+Ide.prototype.linkedEditingRanges = function(cm, pos, callback) {
+  this.client.request("textDocument/linkedEditingRange", this.positionParams(pos)).then(callback, function() {
+    callback(null);
+  });
+};
+
+// showDiagnostics keeps the diagnostics of every document for the problems panel and shows
+// those of the current document through the lint addon.
+Ide.prototype.showDiagnostics = function(params) {
+  if (params.diagnostics && params.diagnostics.length) this.diagnostics[params.uri] = params.diagnostics;
+  else delete this.diagnostics[params.uri];
+  if (params.uri == this.uri) this.editor.setDiagnostics(params.diagnostics);
+  var self = this;
+  if (this.problems) CodeMirror.renderProblems(this.problems, this.diagnostics, function(uri, range) { self.select(uri, range); });
+};
+
+Ide.prototype.bindHover = function() {
+  var self = this, editor = this.editor, timer = null, tooltip = null;
+  function hide() {
+    clearTimeout(timer);
+    if (tooltip && tooltip.parentNode) tooltip.parentNode.removeChild(tooltip);
+    tooltip = null;
+  }
+  CodeMirror.on(editor.getWrapperElement(), "mousemove", function(e) {
+    hide();
+    if (!self.uri) return;
+    var x = e.clientX, y = e.clientY;
+    timer = setTimeout(function() {
+      var pos = editor.coordsChar({left: x, top: y}, "window");
+      var word = editor.findWordAt(pos);
+      if (CodeMirror.cmpPos(word.anchor, word.head) == 0) return;
+      self.client.request("textDocument/hover", self.positionParams(pos)).then(function(hover) {
+        if (!hover || !hover.contents || !hover.contents.value) return;
+        hide();
+        tooltip = document.createElement("pre");
+        tooltip.className = "CodeMirror-lsp-hover";
+        tooltip.textContent = hover.contents.value;
+        tooltip.style.left = (x + window.pageXOffset + 8) + "px";
+        tooltip.style.top = (y + window.pageYOffset + 12) + "px";
+        document.body.appendChild(tooltip);
+      }, function() {});
+    }, 500);
+  });
+  CodeMirror.on(editor.getWrapperElement(), "mouseout", hide);
+  editor.on("keydown", hide);
+};
+
+window.LspClient = LspClient;
+window.Ide = Ide;
+})();
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sourcegraph/jsonrpc2"
+	"lsp/protocol"
+)
+
+// EditorDocument is a document the editor opens when it connects.
+type EditorDocument struct {
+	URI        protocol.DocumentURI `json:"uri"`
+	LanguageID string               `json:"languageId"`
+	Text       string               `json:"text"`
+	Version    int32                `json:"version"`
+}
+
+// EditorInitializeResult answers the initialize request of the editor.
+type EditorInitializeResult struct {
+	RootURI   string           `json:"rootUri"`
+	Documents []EditorDocument `json:"documents"`
+	// CompletionTriggerCharacters open completion when they are typed.
+	CompletionTriggerCharacters []string `json:"completionTriggerCharacters"`
+}
+
+// editorBridge serves the JSON-RPC connection of one browser editor. Document notifications
+// are forwarded in order, requests run concurrently, and diagnostics, code lens refreshes,
+// workspace edits of open documents and connection changes of the language server are pushed
+// back to the editor.
+type editorBridge struct {
+	server    *LanguageServer
+	rootURI   string
+	documents func() []EditorDocument
+	// activity is called for every message of the editor.
+	activity func()
+}
+
+func newEditorBridge(server *LanguageServer, rootURI string, documents func() []EditorDocument, activity func()) *editorBridge {
+	return &editorBridge{server: server, rootURI: rootURI, documents: documents, activity: activity}
+}
+
+// serve runs until the editor disconnects, requests of the editor still running then are
+// canceled. Documents stay open on the language server for the next editor of the session.
+// The editor is disconnected when the language server exits.
+func (b *editorBridge) serve(ctx context.Context, stream jsonrpc2.ObjectStream) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	conn := jsonrpc2.NewConn(ctx, stream, b)
+	diagnostics, cancelDiagnostics := b.server.SubscribeDiagnostics()
+	defer cancelDiagnostics()
+	codeLensRefresh, cancelCodeLensRefresh := b.server.SubscribeCodeLensRefresh()
+	defer cancelCodeLensRefresh()
+	connection, cancelConnection := b.server.SubscribeConnection()
+	defer cancelConnection()
+	documentEdits, cancelDocumentEdits := b.server.SubscribeDocumentEdits()
+	defer cancelDocumentEdits()
+
+	for _, event := range b.server.AllDiagnostics() {
+		b.push(ctx, conn, "textDocument/publishDiagnostics", event)
+	}
+	for {
+		select {
+		case event := <-diagnostics:
+			b.push(ctx, conn, "textDocument/publishDiagnostics", event)
+		case <-codeLensRefresh:
+			b.push(ctx, conn, "workspace/codeLens/refresh", nil)
+		case event := <-connection:
+			b.push(ctx, conn, "$/connection", event)
+		case event := <-documentEdits:
+			b.push(ctx, conn, "ide/documentEdit", event)
+		case <-conn.DisconnectNotify():
+			return
+		case <-b.server.Done():
+			err := conn.Close()
+			if err != nil && !isClosedError(err) {
+				log.Warnf("editorBridge close editor connection failed. err: %s", err)
+			}
+			return
+		}
+	}
+}
+
+func (b *editorBridge) push(ctx context.Context, conn *jsonrpc2.Conn, method string, params interface{}) {
+	err := conn.Notify(ctx, method, params)
+	if err != nil {
+		log.Warnf("editorBridge push failed. method:%s, err: %s", method, err)
+	}
+}
+
+func (b *editorBridge) Handle(ctx context.Context, conn *jsonrpc2.Conn, request *jsonrpc2.Request) {
+	if b.activity != nil {
+		b.activity()
+	}
+	if request.Notif {
+		b.handleNotification(ctx, request)
+		return
+	}
+	go func() {
+		result, respErr := b.handleRequest(ctx, request)
+		var err error
+		if respErr != nil {
+			err = conn.ReplyWithError(ctx, request.ID, respErr)
+		} else {
+			err = conn.Reply(ctx, request.ID, result)
+		}
+		if err != nil {
+			log.Errorf("editorBridge reply failed. method:%s, err: %s", request.Method, err)
+		}
+	}()
+}
+
+func (b *editorBridge) handleNotification(ctx context.Context, request *jsonrpc2.Request) {
+	var err error
+	switch request.Method {
+	case "textDocument/didOpen":
+		params := protocol.DidOpenTextDocumentParams{}
+		if !decodeParams(request, &params) {
+			return
+		}
+		// A reloaded editor opens its documents again with the text initialize gave it, the
+		// open documents already have it and keep the edits of the session.
+		uri := string(params.TextDocument.URI)
+		if _, ok := b.server.Document(uri); ok {
+			break
+		}
+		err = b.server.DidOpenTextDocument(ctx, uri, params.TextDocument.Text, params.TextDocument.LanguageID)
+	case "textDocument/didChange":
+		params := protocol.DidChangeTextDocumentParams{}
+		if !decodeParams(request, &params) {
+			return
+		}
+		// Changes made before a workspace edit reached the editor are dropped, the editor
+		// replaces its text with the one of the edit.
+		err = b.server.DidChangeVersionedTextDocument(ctx, string(params.TextDocument.URI), params.TextDocument.Version, params.ContentChanges)
+	case "textDocument/didSave":
+		params := protocol.DidSaveTextDocumentParams{}
+		if !decodeParams(request, &params) {
+			return
+		}
+		uri := string(params.TextDocument.URI)
+		document, ok := b.server.Document(uri)
+		if params.Text != nil {
+			document.Text, ok = *params.Text, true
+		}
+		if !ok {
+			err = fmt.Errorf("document %s is not open", uri)
+			break
+		}
+		err = b.server.DidSaveTextDocument(ctx, uri, document.Text)
+	case "textDocument/didClose":
+		params := protocol.DidCloseTextDocumentParams{}
+		if !decodeParams(request, &params) {
+			return
+		}
+		err = b.server.DidCloseTextDocument(ctx, string(params.TextDocument.URI))
+	default:
+		log.Infof("editorBridge unhandled notification method:%s", request.Method)
+	}
+	if err != nil {
+		log.Errorf("editorBridge %s failed. err: %s", request.Method, err)
+	}
+}
+
+func (b *editorBridge) handleRequest(ctx context.Context, request *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+	switch request.Method {
+	case "initialize":
+		return EditorInitializeResult{
+			RootURI:                     b.rootURI,
+			Documents:                   b.openDocuments(ctx),
+			CompletionTriggerCharacters: b.server.CompletionTriggerCharacters(),
+		}, nil
+	case "textDocument/completion":
+		params := protocol.CompletionParams{}
+		if respErr := decodeRequestParams(request, &params); respErr != nil {
+			return nil, respErr
+		}
+		return editorResult(b.server.Completion(ctx, string(params.TextDocument.URI), params.Position.Line, params.Position.Character, params.Context))
+	case "ide/completionHints":
+		params := protocol.CompletionParams{}
+		if respErr := decodeRequestParams(request, &params); respErr != nil {
+			return nil, respErr
+		}
+		return editorResult(b.server.CompletionHints(ctx, string(params.TextDocument.URI), params.Position.Line, params.Position.Character, params.Context))
+	case "completionItem/resolve":
+		item := CompletionItem{}
+		if respErr := decodeRequestParams(request, &item); respErr != nil {
+			return nil, respErr
+		}
+		return editorResult(b.server.ResolveCompletionItem(ctx, item))
+	case "textDocument/hover":
+		params := protocol.TextDocumentPositionParams{}
+		if respErr := decodeRequestParams(request, &params); respErr != nil {
+			return nil, respErr
+		}
+		return editorResult(b.server.Hover(ctx, string(params.TextDocument.URI), params.Position.Line, params.Position.Character))
+	case "textDocument/foldingRange":
+		params := protocol.FoldingRangeParams{}
+		if respErr := decodeRequestParams(request, &params); respErr != nil {
+			return nil, respErr
+		}
+		return editorResult(b.server.FoldingRanges(ctx, string(params.TextDocument.URI)))
+	case "textDocument/selectionRange":
+		params := protocol.SelectionRangeParams{}
+		if respErr := decodeRequestParams(request, &params); respErr != nil {
+			return nil, respErr
+		}
+		return editorResult(b.server.SelectionRanges(ctx, string(params.TextDocument.URI), params.Positions...))
+	case "textDocument/linkedEditingRange":
+		params := protocol.TextDocumentPositionParams{}
+		if respErr := decodeRequestParams(request, &params); respErr != nil {
+			return nil, respErr
+		}
+		return editorResult(b.server.LinkedEditingRanges(ctx, string(params.TextDocument.URI), params.Position.Line, params.Position.Character))
+	case "textDocument/codeLens":
+		params := protocol.CodeLensParams{}
+		if respErr := decodeRequestParams(request, &params); respErr != nil {
+			return nil, respErr
+		}
+		return editorResult(b.server.CodeLenses(ctx, string(params.TextDocument.URI)))
+	case "workspace/executeCommand":
+		params := protocol.ExecuteCommandParams{}
+		if respErr := decodeRequestParams(request, &params); respErr != nil {
+			return nil, respErr
+		}
+		arguments := make([]interface{}, 0, len(params.Arguments))
+		for _, argument := range params.Arguments {
+			arguments = append(arguments, argument)
+		}
+		return editorResult(b.server.ExecuteCommand(ctx, params.Command, arguments...))
+	default:
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound, Message: "method not found: " + request.Method}
+	}
+}
+
+// openDocuments opens the documents of the editor that are not open yet, so that each of them
+// comes with the version the editor counts its changes from.
+func (b *editorBridge) openDocuments(ctx context.Context) []EditorDocument {
+	documents := b.documents()
+	for i := range documents {
+		uri := string(documents[i].URI)
+		if _, ok := b.server.Document(uri); !ok {
+			err := b.server.DidOpenTextDocument(ctx, uri, documents[i].Text, documents[i].LanguageID)
+			if err != nil {
+				log.Warnf("editorBridge open document failed. uri:%s, err: %s", uri, err)
+			}
+		}
+		if document, ok := b.server.Document(uri); ok {
+			documents[i].Text = document.Text
+			documents[i].Version = document.Version
+		}
+	}
+	return documents
+}
+
+// editorResult turns the result of a LanguageServer method into a reply. Methods the server
+// does not support answer null so the editor can simply skip the feature.
+func editorResult(result interface{}, err error) (interface{}, *jsonrpc2.Error) {
+	if err == nil {
+		return result, nil
+	}
+	var unsupported *UnsupportedMethodError
+	if errors.As(err, &unsupported) {
+		return nil, nil
+	}
+	return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInternalError, Message: err.Error()}
+}
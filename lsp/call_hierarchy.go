@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"lsp/protocol"
+)
+
+// maxCallTreeNodes stops a call tree from growing without bound on large code bases.
+const maxCallTreeNodes = 1000
+
+type CallDirection string
+
+const (
+	CallsIncoming CallDirection = "incoming"
+	CallsOutgoing CallDirection = "outgoing"
+)
+
+// CallTreeNode is one function in a call tree. For incoming trees the children are the
+// callers, for outgoing trees the callees; FromRanges are the call sites.
+type CallTreeNode struct {
+	Item       protocol.CallHierarchyItem `json:"item"`
+	FromRanges []protocol.Range           `json:"fromRanges,omitempty"`
+	Children   []*CallTreeNode            `json:"children,omitempty"`
+	// Cycle marks a function that is already on the path from the root, it is not expanded again.
+	Cycle bool `json:"cycle,omitempty"`
+	// Truncated marks a node that was not expanded because of the depth or size limit.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func (lsp *LanguageServer) PrepareCallHierarchy(ctx context.Context, uri string, line uint32, character uint32) ([]protocol.CallHierarchyItem, error) {
+	callHierarchyPrepareParams := protocol.CallHierarchyPrepareParams{}
+	callHierarchyPrepareParams.TextDocumentPositionParams = textDocumentPosition(uri, line, character)
+
+	var items []protocol.CallHierarchyItem
+	err := lsp.call(ctx, "textDocument/prepareCallHierarchy", &callHierarchyPrepareParams, &items)
+	if err != nil {
+		return nil, err
+	}
+	if items == nil {
+		items = []protocol.CallHierarchyItem{}
+	}
+	return items, nil
+}
+
+func (lsp *LanguageServer) IncomingCalls(ctx context.Context, item protocol.CallHierarchyItem) ([]protocol.CallHierarchyIncomingCall, error) {
+	var calls []protocol.CallHierarchyIncomingCall
+	err := lsp.call(ctx, "callHierarchy/incomingCalls", &protocol.CallHierarchyIncomingCallsParams{Item: item}, &calls)
+	if err != nil {
+		return nil, err
+	}
+	if calls == nil {
+		calls = []protocol.CallHierarchyIncomingCall{}
+	}
+	return calls, nil
+}
+
+func (lsp *LanguageServer) OutgoingCalls(ctx context.Context, item protocol.CallHierarchyItem) ([]protocol.CallHierarchyOutgoingCall, error) {
+	var calls []protocol.CallHierarchyOutgoingCall
+	err := lsp.call(ctx, "callHierarchy/outgoingCalls", &protocol.CallHierarchyOutgoingCallsParams{Item: item}, &calls)
+	if err != nil {
+		return nil, err
+	}
+	if calls == nil {
+		calls = []protocol.CallHierarchyOutgoingCall{}
+	}
+	return calls, nil
+}
+
+// CallTree expands the calls of the function at the position depth levels deep. A depth
+// of 0 returns only the roots.
+func (lsp *LanguageServer) CallTree(ctx context.Context, uri string, line uint32, character uint32, direction CallDirection, depth int) ([]*CallTreeNode, error) {
+	if direction != CallsIncoming && direction != CallsOutgoing {
+		return nil, fmt.Errorf("unknown call direction %q", direction)
+	}
+	items, err := lsp.PrepareCallHierarchy(ctx, uri, line, character)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := &callTreeBuilder{lsp: lsp, direction: direction, onPath: make(map[string]bool)}
+	roots := make([]*CallTreeNode, 0, len(items))
+	for _, item := range items {
+		root := &CallTreeNode{Item: item}
+		builder.nodes++
+		err = builder.expand(ctx, root, depth)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	return roots, nil
+}
+
+type callTreeBuilder struct {
+	lsp       *LanguageServer
+	direction CallDirection
+	onPath    map[string]bool
+	nodes     int
+}
+
+func (b *callTreeBuilder) expand(ctx context.Context, node *CallTreeNode, depth int) error {
+	if depth <= 0 || b.nodes >= maxCallTreeNodes {
+		node.Truncated = true
+		return nil
+	}
+	key := callHierarchyKey(node.Item)
+	b.onPath[key] = true
+	defer delete(b.onPath, key)
+
+	children, err := b.children(ctx, node.Item)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if b.nodes >= maxCallTreeNodes {
+			node.Truncated = true
+			break
+		}
+		b.nodes++
+		node.Children = append(node.Children, child)
+		if b.onPath[callHierarchyKey(child.Item)] {
+			child.Cycle = true
+			continue
+		}
+		err = b.expand(ctx, child, depth-1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *callTreeBuilder) children(ctx context.Context, item protocol.CallHierarchyItem) ([]*CallTreeNode, error) {
+	var children []*CallTreeNode
+	if b.direction == CallsIncoming {
+		calls, err := b.lsp.IncomingCalls(ctx, item)
+		if err != nil {
+			return nil, err
+		}
+		for _, call := range calls {
+			children = append(children, &CallTreeNode{Item: call.From, FromRanges: call.FromRanges})
+		}
+		return children, nil
+	}
+	calls, err := b.lsp.OutgoingCalls(ctx, item)
+	if err != nil {
+		return nil, err
+	}
+	for _, call := range calls {
+		children = append(children, &CallTreeNode{Item: call.To, FromRanges: call.FromRanges})
+	}
+	return children, nil
+}
+
+func callHierarchyKey(item protocol.CallHierarchyItem) string {
+	start := item.SelectionRange.Start
+	return fmt.Sprintf("%s:%d:%d:%s", item.URI, start.Line, start.Character, item.Name)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sourcegraph/jsonrpc2"
+	"lsp/protocol"
+	"strings"
+	"testing"
+)
+
+// connectCallGraph serves the call hierarchy of graph, which maps a function to the
+// functions it calls. Functions not in graph call fresh functions name+"0" and name+"1".
+func connectCallGraph(t *testing.T, lsp *LanguageServer, root string, graph map[string][]string) {
+	item := func(name string) protocol.CallHierarchyItem {
+		return protocol.CallHierarchyItem{Name: name, URI: "file:///workspace/main.go"}
+	}
+	callees := func(name string) []string {
+		if names, ok := graph[name]; ok {
+			return names
+		}
+		return []string{name + "0", name + "1"}
+	}
+	connectTestServer(t, lsp, `{"callHierarchyProvider":true}`, func(request *jsonrpc2.Request) (interface{}, error) {
+		params := struct {
+			Item protocol.CallHierarchyItem `json:"item"`
+		}{}
+		if request.Params != nil {
+			err := json.Unmarshal(*request.Params, &params)
+			if err != nil {
+				return nil, err
+			}
+		}
+		switch request.Method {
+		case "textDocument/prepareCallHierarchy":
+			return []protocol.CallHierarchyItem{item(root)}, nil
+		case "callHierarchy/outgoingCalls":
+			calls := []protocol.CallHierarchyOutgoingCall{}
+			for _, name := range callees(params.Item.Name) {
+				calls = append(calls, protocol.CallHierarchyOutgoingCall{To: item(name)})
+			}
+			return calls, nil
+		case "callHierarchy/incomingCalls":
+			calls := []protocol.CallHierarchyIncomingCall{}
+			for _, caller := range []string{"main", "a", "b"} {
+				for _, name := range graph[caller] {
+					if name == params.Item.Name {
+						calls = append(calls, protocol.CallHierarchyIncomingCall{From: item(caller)})
+					}
+				}
+			}
+			return calls, nil
+		}
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound, Message: request.Method}
+	})
+}
+
+// formatCallTree writes the tree as `name(children)`, cycles end in * and truncated
+// nodes in ~.
+func formatCallTree(nodes []*CallTreeNode) string {
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		name := node.Item.Name
+		if node.Cycle {
+			name += "*"
+		}
+		if node.Truncated {
+			name += "~"
+		}
+		if len(node.Children) > 0 {
+			name += "(" + formatCallTree(node.Children) + ")"
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestCallTreeCycles(t *testing.T) {
+	graph := map[string][]string{
+		"main": {"a", "b"},
+		"a":    {"b"},
+		"b":    {"a", "b"},
+	}
+	tests := []struct {
+		root      string
+		direction CallDirection
+		depth     int
+		want      string
+	}{
+		{"main", CallsOutgoing, 5, "main(a(b(a* b*)) b(a(b*) b*))"},
+		{"main", CallsOutgoing, 1, "main(a~ b~)"},
+		{"main", CallsOutgoing, 0, "main~"},
+		{"b", CallsIncoming, 1, "b(main~ a~ b*)"},
+		{"b", CallsIncoming, 5, "b(main a(main b*) b*)"},
+	}
+	for _, test := range tests {
+		lsp := newTestLanguageServer()
+		connectCallGraph(t, lsp, test.root, graph)
+		tree, err := lsp.CallTree(context.Background(), "file:///workspace/main.go", 0, 0, test.direction, test.depth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatCallTree(tree); got != test.want {
+			t.Errorf("%s %s calls %d deep = %s, want %s", test.root, test.direction, test.depth, got, test.want)
+		}
+	}
+}
+
+func TestCallTreeLimit(t *testing.T) {
+	lsp := newTestLanguageServer()
+	connectCallGraph(t, lsp, "f", nil)
+	tree, err := lsp.CallTree(context.Background(), "file:///workspace/main.go", 0, 0, CallsOutgoing, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, truncated := 0, 0
+	var walk func(nodes []*CallTreeNode)
+	walk = func(children []*CallTreeNode) {
+		for _, node := range children {
+			nodes++
+			if node.Truncated {
+				truncated++
+			}
+			walk(node.Children)
+		}
+	}
+	walk(tree)
+	if nodes != maxCallTreeNodes || truncated == 0 {
+		t.Fatalf("call tree has %d nodes and %d truncated ones, want %d nodes and some truncated", nodes, truncated, maxCallTreeNodes)
+	}
+}
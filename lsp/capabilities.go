@@ -0,0 +1,177 @@
+package main
+
+import (
+	"lsp/protocol"
+	"os"
+)
+
+var semanticTokenTypes = []string{
+	"namespace", "type", "class", "enum", "interface", "struct", "typeParameter", "parameter", "variable",
+	"property", "enumMember", "event", "function", "method", "macro", "keyword", "modifier", "comment",
+	"string", "number", "regexp", "operator",
+}
+
+var semanticTokenModifiers = []string{
+	"declaration", "definition", "readonly", "static", "deprecated", "abstract", "async", "modification",
+	"documentation", "defaultLibrary",
+}
+
+// ClientOptions describes what the browser editor is able to render. It decides which
+// ClientCapabilities are sent in initialize.
+type ClientOptions struct {
+	Name    string
+	Version string
+
+	// ProcessID defaults to the pid of this process, RootURI to the first workspace folder.
+	ProcessID             int32
+	RootURI               string
+	Trace                 protocol.TraceValues
+	InitializationOptions interface{}
+
+	SnippetSupport      bool
+	MarkdownSupport     bool
+	SemanticTokens      bool
+	ResourceOperations  bool
+	DynamicRegistration bool
+	LineFoldingOnly     bool
+}
+
+// DefaultClientOptions matches the CodeMirror frontend in ../codemirror, which shows hover and
+// documentation as plain text.
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		Name:                "ide",
+		Version:             "v1.0.2",
+		Trace:               "off",
+		SnippetSupport:      true,
+		MarkdownSupport:     false,
+		SemanticTokens:      true,
+		ResourceOperations:  true,
+		DynamicRegistration: true,
+		LineFoldingOnly:     true,
+	}
+}
+
+func (o ClientOptions) initializeParams(folders []protocol.WorkspaceFolder) protocol.InitializeParams {
+	params := protocol.InitializeParams{}
+	params.ClientInfo.Name = o.Name
+	params.ClientInfo.Version = o.Version
+	params.ProcessID = o.ProcessID
+	if params.ProcessID == 0 {
+		params.ProcessID = int32(os.Getpid())
+	}
+	params.RootURI = protocol.DocumentURI(o.RootURI)
+	if params.RootURI == "" && len(folders) > 0 {
+		params.RootURI = protocol.DocumentURI(folders[0].URI)
+	}
+	params.Trace = o.Trace
+	params.InitializationOptions = o.InitializationOptions
+	params.WorkspaceFolders = folders
+	params.Capabilities = o.capabilities()
+	return params
+}
+
+func (o ClientOptions) capabilities() protocol.ClientCapabilities {
+	capabilities := protocol.ClientCapabilities{}
+
+	documentationFormat := []protocol.MarkupKind{protocol.PlainText}
+	if o.MarkdownSupport {
+		documentationFormat = []protocol.MarkupKind{protocol.Markdown, protocol.PlainText}
+	}
+	symbolKinds := make([]protocol.SymbolKind, 0, int(protocol.TypeParameter))
+	for kind := protocol.File; kind <= protocol.TypeParameter; kind++ {
+		symbolKinds = append(symbolKinds, kind)
+	}
+
+	workspace := &capabilities.Workspace
+	workspace.ApplyEdit = true
+	workspace.WorkspaceEdit = &protocol.WorkspaceEditClientCapabilities{
+		DocumentChanges: true,
+		FailureHandling: protocol.Transactional,
+	}
+	if o.ResourceOperations {
+		workspace.WorkspaceEdit.ResourceOperations = []protocol.ResourceOperationKind{protocol.Create, protocol.Rename, protocol.Delete}
+	}
+	workspace.DidChangeConfiguration.DynamicRegistration = o.DynamicRegistration
+	workspace.Symbol = &protocol.WorkspaceSymbolClientCapabilities{}
+	workspace.Symbol.SymbolKind.ValueSet = symbolKinds
+	workspace.Symbol.TagSupport.ValueSet = []protocol.SymbolTag{protocol.DeprecatedSymbol}
+	workspace.WorkspaceFolders = true
+	workspace.SemanticTokens.RefreshSupport = o.SemanticTokens
+	workspace.CodeLens.RefreshSupport = true
+	workspace.Configuration = true
+
+	capabilities.Window.WorkDoneProgress = true
+
+	capabilities.General.StaleRequestSupport.Cancel = true
+	capabilities.General.StaleRequestSupport.RetryOnContentModified = retryOnContentModified
+
+	textDocument := &capabilities.TextDocument
+	textDocument.Synchronization.DidSave = true
+
+	completion := &textDocument.Completion
+	completion.CompletionItem.SnippetSupport = o.SnippetSupport
+	completion.CompletionItem.DocumentationFormat = documentationFormat
+	completion.CompletionItem.DeprecatedSupport = true
+	completion.CompletionItem.PreselectSupport = true
+	completion.CompletionItem.TagSupport.ValueSet = []protocol.CompletionItemTag{protocol.ComplDeprecated}
+	completion.CompletionItem.ResolveSupport.Properties = []string{"documentation", "detail", "additionalTextEdits"}
+	completion.CompletionItem.InsertReplaceSupport = true
+	completion.CompletionItem.LabelDetailsSupport = true
+	for kind := protocol.TextCompletion; kind <= protocol.TypeParameterCompletion; kind++ {
+		completion.CompletionItemKind.ValueSet = append(completion.CompletionItemKind.ValueSet, kind)
+	}
+	completion.ContextSupport = true
+
+	textDocument.Hover.ContentFormat = documentationFormat
+
+	signatureHelp := &textDocument.SignatureHelp
+	signatureHelp.SignatureInformation.DocumentationFormat = documentationFormat
+	signatureHelp.SignatureInformation.ParameterInformation.LabelOffsetSupport = true
+	signatureHelp.SignatureInformation.ActiveParameterSupport = true
+	signatureHelp.ContextSupport = true
+
+	textDocument.Declaration.LinkSupport = true
+	textDocument.Definition.LinkSupport = true
+	textDocument.TypeDefinition.LinkSupport = true
+	textDocument.Implementation.LinkSupport = true
+
+	documentSymbol := &textDocument.DocumentSymbol
+	documentSymbol.SymbolKind.ValueSet = symbolKinds
+	documentSymbol.HierarchicalDocumentSymbolSupport = true
+	documentSymbol.TagSupport.ValueSet = []protocol.SymbolTag{protocol.DeprecatedSymbol}
+
+	codeAction := &textDocument.CodeAction
+	codeAction.CodeActionLiteralSupport.CodeActionKind.ValueSet = []protocol.CodeActionKind{
+		protocol.Empty, protocol.QuickFix, protocol.Refactor, protocol.RefactorExtract, protocol.RefactorInline,
+		protocol.RefactorRewrite, protocol.Source, protocol.SourceOrganizeImports, protocol.SourceFixAll,
+	}
+	codeAction.IsPreferredSupport = true
+	codeAction.DisabledSupport = true
+	codeAction.DataSupport = true
+	codeAction.ResolveSupport.Properties = []string{"edit"}
+
+	textDocument.Rename.PrepareSupport = true
+	// PrepareSupportDefaultBehavior.Identifier
+	textDocument.Rename.PrepareSupportDefaultBehavior = 1
+
+	textDocument.FoldingRange.LineFoldingOnly = o.LineFoldingOnly
+
+	publishDiagnostics := &textDocument.PublishDiagnostics
+	publishDiagnostics.RelatedInformation = true
+	publishDiagnostics.TagSupport.ValueSet = []protocol.DiagnosticTag{protocol.Unnecessary, protocol.Deprecated}
+	publishDiagnostics.VersionSupport = true
+	publishDiagnostics.CodeDescriptionSupport = true
+	publishDiagnostics.DataSupport = true
+
+	if o.SemanticTokens {
+		semanticTokens := &textDocument.SemanticTokens
+		semanticTokens.Requests.Range = true
+		semanticTokens.Requests.Full = map[string]bool{"delta": true}
+		semanticTokens.TokenTypes = semanticTokenTypes
+		semanticTokens.TokenModifiers = semanticTokenModifiers
+		semanticTokens.Formats = []protocol.TokenFormat{"relative"}
+	}
+
+	return capabilities
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lsp/protocol"
+)
+
+// CodeAction is protocol.CodeAction with Edit decoded as the full WorkspaceEdit union.
+// A bare Command returned by the server becomes a CodeAction with only Title and Command set.
+type CodeAction struct {
+	Title       string                  `json:"title"`
+	Kind        protocol.CodeActionKind `json:"kind,omitempty"`
+	Diagnostics []protocol.Diagnostic   `json:"diagnostics,omitempty"`
+	IsPreferred bool                    `json:"isPreferred,omitempty"`
+	Disabled    *struct {
+		Reason string `json:"reason"`
+	} `json:"disabled,omitempty"`
+	Edit    *WorkspaceEdit    `json:"edit,omitempty"`
+	Command *protocol.Command `json:"command,omitempty"`
+	Data    json.RawMessage   `json:"data,omitempty"`
+}
+
+// CodeActions asks for the actions available in codeRange. When diagnostics is nil the
+// cached diagnostics of the document that overlap codeRange are sent as the context.
+func (lsp *LanguageServer) CodeActions(ctx context.Context, uri string, codeRange protocol.Range, diagnostics []protocol.Diagnostic, only ...protocol.CodeActionKind) ([]CodeAction, error) {
+	if diagnostics == nil {
+		diagnostics = []protocol.Diagnostic{}
+		if event, ok := lsp.diagnostics.get(protocol.DocumentURI(uri)); ok {
+			for _, diagnostic := range event.Diagnostics {
+				if rangesOverlap(diagnostic.Range, codeRange) {
+					diagnostics = append(diagnostics, diagnostic)
+				}
+			}
+		}
+	}
+
+	codeActionParams := protocol.CodeActionParams{}
+	codeActionParams.TextDocument.URI = protocol.DocumentURI(uri)
+	codeActionParams.Range = codeRange
+	codeActionParams.Context.Diagnostics = diagnostics
+	codeActionParams.Context.Only = only
+
+	var raw json.RawMessage
+	err := lsp.call(ctx, "textDocument/codeAction", &codeActionParams, &raw)
+	if err != nil {
+		return nil, err
+	}
+	return decodeCodeActions(raw)
+}
+
+// ResolveCodeAction fills in the lazily computed parts of an action, usually its edit.
+func (lsp *LanguageServer) ResolveCodeAction(ctx context.Context, action CodeAction) (CodeAction, error) {
+	resolved := CodeAction{}
+	ok, err := lsp.resolve(ctx, "codeAction/resolve", &action, &resolved)
+	if !ok {
+		return action, err
+	}
+	return resolved, nil
+}
+
+// ExecuteCodeAction applies the edit of the action and then runs its command, resolving
+// the action first if it carries neither.
+func (lsp *LanguageServer) ExecuteCodeAction(ctx context.Context, action CodeAction) error {
+	log.Infof("ExecuteCodeAction start. title:%s, kind:%s", action.Title, action.Kind)
+	if action.Disabled != nil {
+		return fmt.Errorf("code action %q is disabled: %s", action.Title, action.Disabled.Reason)
+	}
+	if action.Edit == nil && action.Command == nil {
+		var err error
+		action, err = lsp.ResolveCodeAction(ctx, action)
+		if err != nil {
+			return err
+		}
+	}
+
+	if action.Edit != nil {
+		err := lsp.ApplyWorkspaceEdit(ctx, *action.Edit)
+		if err != nil {
+			return err
+		}
+	}
+	if action.Command != nil {
+		arguments := make([]interface{}, 0, len(action.Command.Arguments))
+		for _, argument := range action.Command.Arguments {
+			arguments = append(arguments, argument)
+		}
+		_, err := lsp.ExecuteCommand(ctx, action.Command.Command, arguments...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeCodeActions accepts the `(Command | CodeAction)[] | null` union.
+func decodeCodeActions(raw json.RawMessage) ([]CodeAction, error) {
+	actions := []CodeAction{}
+	if isNullResult(raw) {
+		return actions, nil
+	}
+	var items []json.RawMessage
+	err := json.Unmarshal(raw, &items)
+	if err != nil {
+		return nil, fmt.Errorf("lsp textDocument/codeAction: decode result failed: %w", err)
+	}
+	for _, item := range items {
+		command := struct {
+			Command interface{} `json:"command"`
+		}{}
+		err = json.Unmarshal(item, &command)
+		if err != nil {
+			return nil, fmt.Errorf("lsp textDocument/codeAction: decode result failed: %w", err)
+		}
+		if _, ok := command.Command.(string); ok {
+			bare := protocol.Command{}
+			err = json.Unmarshal(item, &bare)
+			if err != nil {
+				return nil, fmt.Errorf("lsp textDocument/codeAction: decode command failed: %w", err)
+			}
+			actions = append(actions, CodeAction{Title: bare.Title, Command: &bare})
+			continue
+		}
+		action := CodeAction{}
+		err = json.Unmarshal(item, &action)
+		if err != nil {
+			return nil, fmt.Errorf("lsp textDocument/codeAction: decode code action failed: %w", err)
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// rangesOverlap treats touching ranges as overlapping so an empty range at the end of a
+// diagnostic still picks it up.
+func rangesOverlap(a, b protocol.Range) bool {
+	return comparePosition(a.Start, b.End) <= 0 && comparePosition(b.Start, a.End) <= 0
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"lsp/protocol"
+	"sync"
+)
+
+// codeLensCache keeps the lenses of each document for the version they were computed on
+// and tells the subscribers when the server asks for all lenses to be refreshed.
+type codeLensCache struct {
+	mutex       sync.Mutex
+	lenses      map[protocol.DocumentURI]versionedCodeLenses
+	subscribers *subscribers
+}
+
+type versionedCodeLenses struct {
+	version int32
+	lenses  []protocol.CodeLens
+}
+
+func newCodeLensCache() *codeLensCache {
+	return &codeLensCache{
+		lenses:      make(map[protocol.DocumentURI]versionedCodeLenses),
+		subscribers: newSubscribers("code lens refresh", true),
+	}
+}
+
+func (c *codeLensCache) get(uri protocol.DocumentURI, version int32) ([]protocol.CodeLens, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, ok := c.lenses[uri]
+	if !ok || cached.version != version {
+		return nil, false
+	}
+	return cached.lenses, true
+}
+
+func (c *codeLensCache) put(uri protocol.DocumentURI, version int32, lenses []protocol.CodeLens) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lenses[uri] = versionedCodeLenses{version: version, lenses: lenses}
+}
+
+func (c *codeLensCache) forget(uri protocol.DocumentURI) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.lenses, uri)
+}
+
+// refresh drops every cached lens and wakes the subscribers. Refreshes that arrive while a
+// subscriber has not caught up yet are merged into one.
+func (c *codeLensCache) refresh() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lenses = make(map[protocol.DocumentURI]versionedCodeLenses)
+	c.subscribers.publish(struct{}{})
+}
+
+func (c *codeLensCache) subscribe() (<-chan struct{}, func()) {
+	subscriber := make(chan struct{}, 1)
+	return subscriber, c.subscribers.add(subscriber)
+}
+
+// CodeLenses returns the lenses of an open document with their commands resolved. They are
+// cached until the document changes or the server sends workspace/codeLens/refresh.
+func (lsp *LanguageServer) CodeLenses(ctx context.Context, uri string) ([]protocol.CodeLens, error) {
+	documentURI := protocol.DocumentURI(uri)
+	document, ok := lsp.documents.get(documentURI)
+	if !ok {
+		return nil, fmt.Errorf("document %s is not open", uri)
+	}
+	if lenses, ok := lsp.codeLenses.get(documentURI, document.Version); ok {
+		return lenses, nil
+	}
+
+	codeLensParams := protocol.CodeLensParams{}
+	codeLensParams.TextDocument.URI = documentURI
+	var lenses []protocol.CodeLens
+	err := lsp.call(ctx, "textDocument/codeLens", &codeLensParams, &lenses)
+	if err != nil {
+		return nil, err
+	}
+	if lenses == nil {
+		lenses = []protocol.CodeLens{}
+	}
+
+	for i := range lenses {
+		if lenses[i].Command.Command != "" {
+			continue
+		}
+		lenses[i], err = lsp.ResolveCodeLens(ctx, lenses[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	lsp.codeLenses.put(documentURI, document.Version, lenses)
+	return lenses, nil
+}
+
+// unresolvedCodeLens is a protocol.CodeLens whose command is left out instead of being sent
+// empty, protocol.CodeLens always has one.
+type unresolvedCodeLens struct {
+	Range   protocol.Range    `json:"range"`
+	Command *protocol.Command `json:"command,omitempty"`
+	Data    interface{}       `json:"data,omitempty"`
+}
+
+// ResolveCodeLens fills in the command of a lens.
+func (lsp *LanguageServer) ResolveCodeLens(ctx context.Context, lens protocol.CodeLens) (protocol.CodeLens, error) {
+	params := unresolvedCodeLens{Range: lens.Range, Data: lens.Data}
+	if lens.Command.Command != "" {
+		params.Command = &lens.Command
+	}
+	resolved := protocol.CodeLens{}
+	ok, err := lsp.resolve(ctx, "codeLens/resolve", &params, &resolved)
+	if !ok {
+		return lens, err
+	}
+	return resolved, nil
+}
+
+// ExecuteCodeLens runs the command of a lens through workspace/executeCommand.
+func (lsp *LanguageServer) ExecuteCodeLens(ctx context.Context, lens protocol.CodeLens) (interface{}, error) {
+	if lens.Command.Command == "" {
+		var err error
+		lens, err = lsp.ResolveCodeLens(ctx, lens)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if lens.Command.Command == "" {
+		return nil, fmt.Errorf("code lens at %d:%d has no command", lens.Range.Start.Line, lens.Range.Start.Character)
+	}
+	log.Infof("ExecuteCodeLens start. title:%s, command:%s", lens.Command.Title, lens.Command.Command)
+	arguments := make([]interface{}, 0, len(lens.Command.Arguments))
+	for _, argument := range lens.Command.Arguments {
+		arguments = append(arguments, argument)
+	}
+	return lsp.ExecuteCommand(ctx, lens.Command.Command, arguments...)
+}
+
+// SubscribeCodeLensRefresh signals every workspace/codeLens/refresh until the returned cancel
+// func is called. Lenses shown in an editor should be requested again.
+func (lsp *LanguageServer) SubscribeCodeLensRefresh() (<-chan struct{}, func()) {
+	return lsp.codeLenses.subscribe()
+}
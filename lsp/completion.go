@@ -0,0 +1,549 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lsp/protocol"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+var completionItemKinds = map[protocol.CompletionItemKind]string{
+	protocol.TextCompletion:          "text",
+	protocol.MethodCompletion:        "method",
+	protocol.FunctionCompletion:      "function",
+	protocol.ConstructorCompletion:   "constructor",
+	protocol.FieldCompletion:         "field",
+	protocol.VariableCompletion:      "variable",
+	protocol.ClassCompletion:         "class",
+	protocol.InterfaceCompletion:     "interface",
+	protocol.ModuleCompletion:        "module",
+	protocol.PropertyCompletion:      "property",
+	protocol.UnitCompletion:          "unit",
+	protocol.ValueCompletion:         "value",
+	protocol.EnumCompletion:          "enum",
+	protocol.KeywordCompletion:       "keyword",
+	protocol.SnippetCompletion:       "snippet",
+	protocol.ColorCompletion:         "color",
+	protocol.FileCompletion:          "file",
+	protocol.ReferenceCompletion:     "reference",
+	protocol.FolderCompletion:        "folder",
+	protocol.EnumMemberCompletion:    "enumMember",
+	protocol.ConstantCompletion:      "constant",
+	protocol.StructCompletion:        "struct",
+	protocol.EventCompletion:         "event",
+	protocol.OperatorCompletion:      "operator",
+	protocol.TypeParameterCompletion: "typeParameter",
+}
+
+// CompletionItem is protocol.CompletionItem with the unions decoded: Documentation is always
+// MarkupContent and TextEdit holds either a TextEdit or an InsertReplaceEdit.
+type CompletionItem struct {
+	Label               string                               `json:"label"`
+	LabelDetails        *protocol.CompletionItemLabelDetails `json:"labelDetails,omitempty"`
+	Kind                protocol.CompletionItemKind          `json:"kind,omitempty"`
+	Tags                []protocol.CompletionItemTag         `json:"tags,omitempty"`
+	Detail              string                               `json:"detail,omitempty"`
+	Documentation       *protocol.MarkupContent              `json:"documentation,omitempty"`
+	Deprecated          bool                                 `json:"deprecated,omitempty"`
+	Preselect           bool                                 `json:"preselect,omitempty"`
+	SortText            string                               `json:"sortText,omitempty"`
+	FilterText          string                               `json:"filterText,omitempty"`
+	InsertText          string                               `json:"insertText,omitempty"`
+	InsertTextFormat    protocol.InsertTextFormat            `json:"insertTextFormat,omitempty"`
+	InsertTextMode      protocol.InsertTextMode              `json:"insertTextMode,omitempty"`
+	TextEdit            *CompletionTextEdit                  `json:"textEdit,omitempty"`
+	AdditionalTextEdits []protocol.TextEdit                  `json:"additionalTextEdits,omitempty"`
+	CommitCharacters    []string                             `json:"commitCharacters,omitempty"`
+	Command             *protocol.Command                    `json:"command,omitempty"`
+	Data                json.RawMessage                      `json:"data,omitempty"`
+}
+
+func (i *CompletionItem) UnmarshalJSON(data []byte) error {
+	type completionItem CompletionItem
+	item := struct {
+		*completionItem
+		Documentation json.RawMessage `json:"documentation"`
+	}{completionItem: (*completionItem)(i)}
+	err := json.Unmarshal(data, &item)
+	if err != nil {
+		return err
+	}
+	i.Documentation = nil
+	if len(item.Documentation) > 0 && !isNullResult(item.Documentation) {
+		documentation, err := decodeMarkup(item.Documentation, protocol.PlainText)
+		if err != nil {
+			return err
+		}
+		i.Documentation = &documentation
+	}
+	return nil
+}
+
+// CompletionTextEdit is the `TextEdit | InsertReplaceEdit` union. A TextEdit has the same
+// Insert and Replace range.
+type CompletionTextEdit struct {
+	NewText string
+	Insert  protocol.Range
+	Replace protocol.Range
+}
+
+func (e *CompletionTextEdit) UnmarshalJSON(data []byte) error {
+	edit := struct {
+		NewText string          `json:"newText"`
+		Range   *protocol.Range `json:"range"`
+		Insert  protocol.Range  `json:"insert"`
+		Replace protocol.Range  `json:"replace"`
+	}{}
+	err := json.Unmarshal(data, &edit)
+	if err != nil {
+		return err
+	}
+	e.NewText = edit.NewText
+	if edit.Range != nil {
+		e.Insert, e.Replace = *edit.Range, *edit.Range
+		return nil
+	}
+	e.Insert, e.Replace = edit.Insert, edit.Replace
+	return nil
+}
+
+func (e CompletionTextEdit) MarshalJSON() ([]byte, error) {
+	if e.Insert == e.Replace {
+		return json.Marshal(protocol.TextEdit{Range: e.Insert, NewText: e.NewText})
+	}
+	return json.Marshal(protocol.InsertReplaceEdit{NewText: e.NewText, Insert: e.Insert, Replace: e.Replace})
+}
+
+type CompletionList struct {
+	IsIncomplete bool             `json:"isIncomplete"`
+	Items        []CompletionItem `json:"items"`
+}
+
+// HintPosition is a position the way CodeMirror counts it, ch in UTF-16 code units.
+type HintPosition struct {
+	Line uint32 `json:"line"`
+	Ch   uint32 `json:"ch"`
+}
+
+// SnippetTabstop is a tab stop of an expanded snippet, Start and End are UTF-16 offsets
+// into the hint text. Index 0 is the final cursor position.
+type SnippetTabstop struct {
+	Index int `json:"index"`
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// CompletionHint is one entry of a CodeMirror show-hint list. Text replaces From to To,
+// snippets are already expanded into Text and Tabstops.
+type CompletionHint struct {
+	Text                string              `json:"text"`
+	DisplayText         string              `json:"displayText"`
+	ClassName           string              `json:"className"`
+	From                HintPosition        `json:"from"`
+	To                  HintPosition        `json:"to"`
+	Kind                string              `json:"kind,omitempty"`
+	Detail              string              `json:"detail,omitempty"`
+	Tabstops            []SnippetTabstop    `json:"tabstops,omitempty"`
+	AdditionalTextEdits []protocol.TextEdit `json:"additionalTextEdits,omitempty"`
+	// Item is sent back to completionItem/resolve for the documentation.
+	Item CompletionItem `json:"item"`
+}
+
+// CompletionHints is the answer of a CodeMirror hint function: the list and the word it
+// completes.
+type CompletionHints struct {
+	From         HintPosition     `json:"from"`
+	To           HintPosition     `json:"to"`
+	List         []CompletionHint `json:"list"`
+	IsIncomplete bool             `json:"isIncomplete"`
+}
+
+// Completion requests the items at the position. A completionContext without TriggerKind is
+// filled in from the document: typing one of the trigger characters of the server triggers
+// completion, anything else invokes it.
+func (lsp *LanguageServer) Completion(ctx context.Context, uri string, line uint32, character uint32, completionContext protocol.CompletionContext) (*CompletionList, error) {
+	completionParams := protocol.CompletionParams{}
+	completionParams.TextDocument.URI = protocol.DocumentURI(uri)
+	completionParams.Position.Line = line
+	completionParams.Position.Character = character
+	completionParams.Context = lsp.completionContext(uri, line, character, completionContext)
+
+	var raw json.RawMessage
+	err := lsp.call(ctx, "textDocument/completion", &completionParams, &raw)
+	if err != nil {
+		return nil, err
+	}
+	return decodeCompletionResult(raw)
+}
+
+// CompletionTriggerCharacters returns the characters that trigger completion, advertised in
+// completionProvider or a dynamic textDocument/completion registration.
+func (lsp *LanguageServer) CompletionTriggerCharacters() []string {
+	lsp.mutex.Lock()
+	options := []interface{}{}
+	if provider, ok := lookupOption(lsp.capabilities.raw, []string{"completionProvider"}); ok {
+		options = append(options, provider)
+	}
+	for _, registration := range lsp.registrations {
+		if registration.Method == "textDocument/completion" {
+			options = append(options, registration.RegisterOptions)
+		}
+	}
+	lsp.mutex.Unlock()
+
+	characters := []string{}
+	seen := make(map[string]bool)
+	for _, option := range options {
+		triggerCharacters, _ := lookupOption(option, []string{"triggerCharacters"})
+		values, _ := triggerCharacters.([]interface{})
+		for _, value := range values {
+			character, ok := value.(string)
+			if ok && character != "" && !seen[character] {
+				seen[character] = true
+				characters = append(characters, character)
+			}
+		}
+	}
+	return characters
+}
+
+func (lsp *LanguageServer) completionContext(uri string, line uint32, character uint32, completionContext protocol.CompletionContext) protocol.CompletionContext {
+	if completionContext.TriggerKind == protocol.Invoked || completionContext.TriggerKind == protocol.TriggerForIncompleteCompletions {
+		return protocol.CompletionContext{TriggerKind: completionContext.TriggerKind}
+	}
+	if completionContext.TriggerKind != protocol.TriggerCharacter {
+		completionContext.TriggerCharacter = ""
+		if document, ok := lsp.documents.get(protocol.DocumentURI(uri)); ok && character > 0 {
+			completionContext.TriggerCharacter = utf16Slice(documentLine(document.Text, line), character-1, character)
+		}
+	}
+	for _, triggerCharacter := range lsp.CompletionTriggerCharacters() {
+		if triggerCharacter == completionContext.TriggerCharacter {
+			return protocol.CompletionContext{TriggerKind: protocol.TriggerCharacter, TriggerCharacter: triggerCharacter}
+		}
+	}
+	return protocol.CompletionContext{TriggerKind: protocol.Invoked}
+}
+
+// ResolveCompletionItem fills in the lazily computed parts of an item, usually its
+// documentation.
+func (lsp *LanguageServer) ResolveCompletionItem(ctx context.Context, item CompletionItem) (CompletionItem, error) {
+	resolved := CompletionItem{}
+	ok, err := lsp.resolve(ctx, "completionItem/resolve", &item, &resolved)
+	if !ok {
+		return item, err
+	}
+	return resolved, nil
+}
+
+// CompletionHints completes the word at the position of an open document and turns the
+// items into CodeMirror hints ordered by SortText and filtered by FilterText.
+func (lsp *LanguageServer) CompletionHints(ctx context.Context, uri string, line uint32, character uint32, completionContext protocol.CompletionContext) (*CompletionHints, error) {
+	document, ok := lsp.documents.get(protocol.DocumentURI(uri))
+	if !ok {
+		return nil, fmt.Errorf("document %s is not open", uri)
+	}
+	completionList, err := lsp.Completion(ctx, uri, line, character, completionContext)
+	if err != nil {
+		return nil, err
+	}
+	lineText := documentLine(document.Text, line)
+	cursor := HintPosition{Line: line, Ch: character}
+	hints := &CompletionHints{From: HintPosition{Line: line, Ch: wordStart(lineText, character)}, To: cursor, List: []CompletionHint{}, IsIncomplete: completionList.IsIncomplete}
+
+	type scoredHint struct {
+		hint  CompletionHint
+		score int
+	}
+	scored := make([]scoredHint, 0, len(completionList.Items))
+	for _, item := range completionList.Items {
+		hint := completionHint(item, hints.From, cursor)
+		filterText := item.FilterText
+		if filterText == "" {
+			filterText = item.Label
+		}
+		score := 0
+		if hint.From.Line == line && hint.From.Ch <= character {
+			score, ok = fuzzyScore(utf16Slice(lineText, hint.From.Ch, character), filterText)
+			if !ok {
+				continue
+			}
+		}
+		scored = append(scored, scoredHint{hint: hint, score: score})
+	}
+	sort.SliceStable(scored, func(i, j int) bool {
+		a, b := scored[i].hint.Item, scored[j].hint.Item
+		if a.Preselect != b.Preselect {
+			return a.Preselect
+		}
+		if sortText(a) != sortText(b) {
+			return sortText(a) < sortText(b)
+		}
+		return scored[i].score > scored[j].score
+	})
+	for _, s := range scored {
+		hints.List = append(hints.List, s.hint)
+	}
+	return hints, nil
+}
+
+func completionHint(item CompletionItem, from, to HintPosition) CompletionHint {
+	hint := CompletionHint{
+		Text:                item.Label,
+		DisplayText:         item.Label,
+		ClassName:           "CodeMirror-hint-lsp",
+		From:                from,
+		To:                  to,
+		Kind:                completionItemKinds[item.Kind],
+		Detail:              item.Detail,
+		AdditionalTextEdits: item.AdditionalTextEdits,
+		Item:                item,
+	}
+	if item.LabelDetails != nil {
+		hint.DisplayText += item.LabelDetails.Detail
+	}
+	if item.InsertText != "" {
+		hint.Text = item.InsertText
+	}
+	if item.TextEdit != nil {
+		hint.Text = item.TextEdit.NewText
+		hint.From = HintPosition{Line: item.TextEdit.Insert.Start.Line, Ch: item.TextEdit.Insert.Start.Character}
+		hint.To = HintPosition{Line: item.TextEdit.Insert.End.Line, Ch: item.TextEdit.Insert.End.Character}
+	}
+	if item.InsertTextFormat == protocol.SnippetTextFormat {
+		hint.Text, hint.Tabstops = expandSnippet(hint.Text)
+	}
+	if hint.Kind != "" {
+		hint.ClassName += " CodeMirror-hint-kind-" + hint.Kind
+	}
+	if item.Deprecated || hasCompletionItemTag(item.Tags, protocol.ComplDeprecated) {
+		hint.ClassName += " CodeMirror-hint-deprecated"
+	}
+	return hint
+}
+
+func sortText(item CompletionItem) string {
+	if item.SortText != "" {
+		return item.SortText
+	}
+	return item.Label
+}
+
+func hasCompletionItemTag(tags []protocol.CompletionItemTag, tag protocol.CompletionItemTag) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// documentLine returns the line of text without its line break, "" past the end.
+func documentLine(text string, line uint32) string {
+	lines := strings.SplitAfter(text, "\n")
+	if int(line) >= len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[line], "\r\n")
+}
+
+// wordStart returns where the identifier ending at character starts, in UTF-16 code units.
+func wordStart(line string, character uint32) uint32 {
+	var offset, start uint32
+	for _, r := range line {
+		size := uint32(utf16.RuneLen(r))
+		if offset+size > character {
+			break
+		}
+		offset += size
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			start = offset
+		}
+	}
+	return start
+}
+
+// expandSnippet turns LSP snippet syntax into plain text and the tab stops in it:
+// $1, ${1}, ${1:placeholder} (nested), ${1|one,two|} (first choice), variables and escapes.
+func expandSnippet(snippet string) (string, []SnippetTabstop) {
+	parser := &snippetParser{input: []rune(snippet)}
+	parser.parse(false)
+	// Without $0 the cursor ends up at the end of the snippet.
+	if len(parser.tabstops) > 0 && !hasFinalTabstop(parser.tabstops) {
+		parser.tabstops = append(parser.tabstops, SnippetTabstop{Start: parser.length, End: parser.length})
+	}
+	sort.SliceStable(parser.tabstops, func(i, j int) bool {
+		a, b := parser.tabstops[i].Index, parser.tabstops[j].Index
+		if (a == 0) != (b == 0) {
+			return b == 0
+		}
+		return a < b
+	})
+	return string(parser.output), parser.tabstops
+}
+
+func hasFinalTabstop(tabstops []SnippetTabstop) bool {
+	for _, tabstop := range tabstops {
+		if tabstop.Index == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type snippetParser struct {
+	input    []rune
+	pos      int
+	output   []rune
+	length   int
+	tabstops []SnippetTabstop
+	// placeholders are the texts of the tab stops, repeated where the tab stop is mirrored.
+	placeholders map[int]string
+}
+
+func (p *snippetParser) write(r rune) {
+	p.output = append(p.output, r)
+	p.length += len(utf16.Encode([]rune{r}))
+}
+
+// parse copies text until the end or, inside a placeholder, until the closing brace.
+func (p *snippetParser) parse(nested bool) {
+	for p.pos < len(p.input) {
+		r := p.input[p.pos]
+		switch {
+		case r == '\\' && p.pos+1 < len(p.input) && strings.ContainsRune(`$}\,|`, p.input[p.pos+1]):
+			p.write(p.input[p.pos+1])
+			p.pos += 2
+		case r == '}' && nested:
+			return
+		case r == '$':
+			p.pos++
+			p.parseDollar()
+		default:
+			p.write(r)
+			p.pos++
+		}
+	}
+}
+
+func (p *snippetParser) parseDollar() {
+	if p.pos < len(p.input) && isSnippetDigit(p.input[p.pos]) {
+		start, index := p.length, p.number()
+		for _, r := range p.placeholders[index] {
+			p.write(r)
+		}
+		p.tabstops = append(p.tabstops, SnippetTabstop{Index: index, Start: start, End: p.length})
+		return
+	}
+	if p.pos < len(p.input) && isSnippetVariableRune(p.input[p.pos]) {
+		for p.pos < len(p.input) && isSnippetVariableRune(p.input[p.pos]) {
+			p.pos++
+		}
+		return
+	}
+	if p.pos >= len(p.input) || p.input[p.pos] != '{' {
+		p.write('$')
+		return
+	}
+	p.pos++
+
+	if p.pos < len(p.input) && isSnippetDigit(p.input[p.pos]) {
+		index := p.number()
+		start, output := p.length, len(p.output)
+		if p.pos < len(p.input) && p.input[p.pos] == ':' {
+			p.pos++
+			p.parse(true)
+		} else if p.pos < len(p.input) && p.input[p.pos] == '|' {
+			p.pos++
+			p.parseChoice()
+		}
+		p.skip('}')
+		if p.placeholders == nil {
+			p.placeholders = make(map[int]string)
+		}
+		p.placeholders[index] = string(p.output[output:])
+		p.tabstops = append(p.tabstops, SnippetTabstop{Index: index, Start: start, End: p.length})
+		return
+	}
+	for p.pos < len(p.input) && isSnippetVariableRune(p.input[p.pos]) {
+		p.pos++
+	}
+	if p.pos < len(p.input) && p.input[p.pos] == ':' {
+		p.pos++
+		p.parse(true)
+	} else {
+		for p.pos < len(p.input) && p.input[p.pos] != '}' {
+			p.pos++
+		}
+	}
+	p.skip('}')
+}
+
+// parseChoice writes the first choice of `one,two|` and skips the others.
+func (p *snippetParser) parseChoice() {
+	first := true
+	for p.pos < len(p.input) && p.input[p.pos] != '|' {
+		r := p.input[p.pos]
+		if r == '\\' && p.pos+1 < len(p.input) {
+			p.pos++
+			r = p.input[p.pos]
+		} else if r == ',' {
+			first = false
+			p.pos++
+			continue
+		}
+		if first {
+			p.write(r)
+		}
+		p.pos++
+	}
+	p.skip('|')
+}
+
+func (p *snippetParser) number() int {
+	n := 0
+	for p.pos < len(p.input) && isSnippetDigit(p.input[p.pos]) {
+		n = n*10 + int(p.input[p.pos]-'0')
+		p.pos++
+	}
+	return n
+}
+
+func (p *snippetParser) skip(r rune) {
+	if p.pos < len(p.input) && p.input[p.pos] == r {
+		p.pos++
+	}
+}
+
+// isSnippetDigit only takes ASCII digits, tab stop numbers are computed from them.
+func isSnippetDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
+
+func isSnippetVariableRune(r rune) bool {
+	return r == '_' || (r < unicode.MaxASCII && unicode.IsLetter(r))
+}
+
+// decodeCompletionResult accepts the `CompletionItem[] | CompletionList | null` union.
+func decodeCompletionResult(raw json.RawMessage) (*CompletionList, error) {
+	completionList := CompletionList{Items: []CompletionItem{}}
+	if isNullResult(raw) {
+		return &completionList, nil
+	}
+	if raw[0] == '[' {
+		err := json.Unmarshal(raw, &completionList.Items)
+		if err != nil {
+			return nil, fmt.Errorf("lsp textDocument/completion: decode result failed: %w", err)
+		}
+		return &completionList, nil
+	}
+	err := json.Unmarshal(raw, &completionList)
+	if err != nil {
+		return nil, fmt.Errorf("lsp textDocument/completion: decode result failed: %w", err)
+	}
+	return &completionList, nil
+}
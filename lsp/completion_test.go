@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"lsp/protocol"
+	"reflect"
+	"testing"
+)
+
+func TestWordStart(t *testing.T) {
+	tests := []struct {
+		line      string
+		character uint32
+		want      uint32
+	}{
+		{"", 0, 0},
+		{"foo", 3, 0},
+		{"fmt.Pri", 7, 4},
+		{"fmt.Pri", 4, 4},
+		{"a + b", 3, 3},
+		{"_a1", 3, 0},
+		{"foo bar", 100, 4},
+		{"héllo", 5, 0},
+		{"x := 𝒳yz", 9, 5},
+		{"x := y𝒳z", 9, 5},
+		{"𝒳y", 1, 0},
+	}
+	for _, test := range tests {
+		if got := wordStart(test.line, test.character); got != test.want {
+			t.Errorf("wordStart(%q, %d) = %d, want %d", test.line, test.character, got, test.want)
+		}
+	}
+}
+
+func TestExpandSnippet(t *testing.T) {
+	tests := []struct {
+		snippet  string
+		text     string
+		tabstops []SnippetTabstop
+	}{
+		{"foo", "foo", nil},
+		{"$", "$", nil},
+		{"fmt.Println(${1:a})", "fmt.Println(a)", []SnippetTabstop{{1, 12, 13}, {0, 14, 14}}},
+		{"for $1 {\n\t$0\n}", "for  {\n\t\n}", []SnippetTabstop{{1, 4, 4}, {0, 8, 8}}},
+		{"${1:outer ${2:inner}}", "outer inner", []SnippetTabstop{{1, 0, 11}, {2, 6, 11}, {0, 11, 11}}},
+		{"${1|one,two|}", "one", []SnippetTabstop{{1, 0, 3}, {0, 3, 3}}},
+		{`${1|a\,b,c|}`, "a,b", []SnippetTabstop{{1, 0, 3}, {0, 3, 3}}},
+		{`\$1 \} \\ ${1:a\}b}`, `$1 } \ a}b`, []SnippetTabstop{{1, 7, 10}, {0, 10, 10}}},
+		{"$TM_FILENAME ${TM_SELECTED_TEXT:default} ${UNKNOWN}$0", " default ", []SnippetTabstop{{0, 9, 9}}},
+		{"${1:x} = $1", "x = x", []SnippetTabstop{{1, 0, 1}, {1, 4, 5}, {0, 5, 5}}},
+		{"${2:b}, ${1:a}", "b, a", []SnippetTabstop{{1, 3, 4}, {2, 0, 1}, {0, 4, 4}}},
+		{"${1:𝒳}", "𝒳", []SnippetTabstop{{1, 0, 2}, {0, 2, 2}}},
+	}
+	for _, test := range tests {
+		text, tabstops := expandSnippet(test.snippet)
+		if text != test.text || !reflect.DeepEqual(tabstops, test.tabstops) {
+			t.Errorf("expandSnippet(%q) = %q, %v, want %q, %v", test.snippet, text, tabstops, test.text, test.tabstops)
+		}
+	}
+}
+
+func TestCompletionContext(t *testing.T) {
+	lsp := newTestLanguageServer()
+	_, capabilities, err := decodeInitializeResult(json.RawMessage(`{"capabilities":{"completionProvider":{"triggerCharacters":["."]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lsp.capabilities = capabilities
+	uri := "file:///workspace/main.go"
+	_, err = lsp.documents.open(protocol.DocumentURI(uri), "go", "fmt.\nx := a>b\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		uri       string
+		line      uint32
+		character uint32
+		context   protocol.CompletionContext
+		want      protocol.CompletionContext
+	}{
+		{uri, 0, 4, protocol.CompletionContext{}, protocol.CompletionContext{TriggerKind: protocol.TriggerCharacter, TriggerCharacter: "."}},
+		{uri, 1, 1, protocol.CompletionContext{}, protocol.CompletionContext{TriggerKind: protocol.Invoked}},
+		{uri, 1, 7, protocol.CompletionContext{}, protocol.CompletionContext{TriggerKind: protocol.Invoked}},
+		{uri, 0, 0, protocol.CompletionContext{}, protocol.CompletionContext{TriggerKind: protocol.Invoked}},
+		{"file:///workspace/other.go", 0, 4, protocol.CompletionContext{}, protocol.CompletionContext{TriggerKind: protocol.Invoked}},
+		{uri, 0, 4, protocol.CompletionContext{TriggerKind: protocol.Invoked}, protocol.CompletionContext{TriggerKind: protocol.Invoked}},
+		{uri, 0, 4, protocol.CompletionContext{TriggerKind: protocol.TriggerForIncompleteCompletions, TriggerCharacter: "."}, protocol.CompletionContext{TriggerKind: protocol.TriggerForIncompleteCompletions}},
+		{uri, 1, 1, protocol.CompletionContext{TriggerKind: protocol.TriggerCharacter, TriggerCharacter: "."}, protocol.CompletionContext{TriggerKind: protocol.TriggerCharacter, TriggerCharacter: "."}},
+		{uri, 1, 7, protocol.CompletionContext{TriggerKind: protocol.TriggerCharacter, TriggerCharacter: ">"}, protocol.CompletionContext{TriggerKind: protocol.Invoked}},
+	}
+	for _, test := range tests {
+		got := lsp.completionContext(test.uri, test.line, test.character, test.context)
+		if got != test.want {
+			t.Errorf("completionContext(%s, %d, %d, %+v) = %+v, want %+v", test.uri, test.line, test.character, test.context, got, test.want)
+		}
+	}
+}
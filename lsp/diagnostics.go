@@ -0,0 +1,83 @@
+package main
+
+import (
+	"lsp/protocol"
+	"sort"
+	"sync"
+)
+
+const diagnosticsSubscriberBuffer = 64
+
+type DiagnosticsEvent struct {
+	URI         protocol.DocumentURI  `json:"uri"`
+	Version     int32                 `json:"version,omitempty"`
+	Diagnostics []protocol.Diagnostic `json:"diagnostics"`
+}
+
+// diagnosticsStore keeps the latest diagnostics the server published for each document.
+type diagnosticsStore struct {
+	mutex       sync.Mutex
+	entries     map[protocol.DocumentURI]DiagnosticsEvent
+	subscribers *subscribers
+}
+
+func newDiagnosticsStore() *diagnosticsStore {
+	return &diagnosticsStore{
+		entries:     make(map[protocol.DocumentURI]DiagnosticsEvent),
+		subscribers: newSubscribers("diagnostics", false),
+	}
+}
+
+// publish records the diagnostics unless they belong to an older version than the
+// ones already stored, and reports whether they were accepted.
+func (s *diagnosticsStore) publish(params protocol.PublishDiagnosticsParams) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if current, ok := s.entries[params.URI]; ok && params.Version != 0 && params.Version < current.Version {
+		log.Infof("drop stale diagnostics. uri:%s, version:%d, current version:%d", params.URI, params.Version, current.Version)
+		return false
+	}
+
+	event := DiagnosticsEvent{URI: params.URI, Version: params.Version, Diagnostics: params.Diagnostics}
+	if event.Diagnostics == nil {
+		event.Diagnostics = []protocol.Diagnostic{}
+	}
+	if len(event.Diagnostics) == 0 {
+		delete(s.entries, params.URI)
+	} else {
+		s.entries[params.URI] = event
+	}
+
+	s.subscribers.publish(event)
+	return true
+}
+
+// clear drops every stored diagnostic, telling subscribers each document is clean again.
+func (s *diagnosticsStore) clear() {
+	for _, event := range s.all() {
+		s.publish(protocol.PublishDiagnosticsParams{URI: event.URI})
+	}
+}
+
+func (s *diagnosticsStore) get(uri protocol.DocumentURI) (DiagnosticsEvent, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	event, ok := s.entries[uri]
+	return event, ok
+}
+
+func (s *diagnosticsStore) all() []DiagnosticsEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	events := make([]DiagnosticsEvent, 0, len(s.entries))
+	for _, event := range s.entries {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].URI < events[j].URI })
+	return events
+}
+
+func (s *diagnosticsStore) subscribe() (<-chan DiagnosticsEvent, func()) {
+	subscriber := make(chan DiagnosticsEvent, diagnosticsSubscriberBuffer)
+	return subscriber, s.subscribers.add(subscriber)
+}
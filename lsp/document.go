@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"lsp/protocol"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+type TextDocument struct {
+	URI        protocol.DocumentURI
+	LanguageID string
+	Text       string
+	Version    int32
+}
+
+// documentStore tracks the documents opened on the server. Versions keep growing
+// across close and reopen so the server never sees a version go backwards.
+type documentStore struct {
+	mutex     sync.Mutex
+	documents map[protocol.DocumentURI]*TextDocument
+	versions  map[protocol.DocumentURI]int32
+}
+
+func newDocumentStore() *documentStore {
+	return &documentStore{
+		documents: make(map[protocol.DocumentURI]*TextDocument),
+		versions:  make(map[protocol.DocumentURI]int32),
+	}
+}
+
+func (s *documentStore) open(uri protocol.DocumentURI, languageID, text string) (TextDocument, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.documents[uri]; ok {
+		return TextDocument{}, fmt.Errorf("document %s is already open", uri)
+	}
+	s.versions[uri]++
+	document := &TextDocument{URI: uri, LanguageID: languageID, Text: text, Version: s.versions[uri]}
+	s.documents[uri] = document
+	return *document, nil
+}
+
+// change applies the edits in order and bumps the version only if all of them apply.
+func (s *documentStore) change(uri protocol.DocumentURI, changes []protocol.TextDocumentContentChangeEvent) (TextDocument, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	document, ok := s.documents[uri]
+	if !ok {
+		return TextDocument{}, fmt.Errorf("document %s is not open", uri)
+	}
+
+	text := document.Text
+	for i, change := range changes {
+		var err error
+		text, err = applyContentChange(text, change)
+		if err != nil {
+			return TextDocument{}, fmt.Errorf("document %s change %d: %w", uri, i, err)
+		}
+	}
+
+	s.versions[uri]++
+	document.Text = text
+	document.Version = s.versions[uri]
+	return *document, nil
+}
+
+func (s *documentStore) close(uri protocol.DocumentURI) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.documents[uri]; !ok {
+		return fmt.Errorf("document %s is not open", uri)
+	}
+	delete(s.documents, uri)
+	return nil
+}
+
+func (s *documentStore) get(uri protocol.DocumentURI) (TextDocument, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	document, ok := s.documents[uri]
+	if !ok {
+		return TextDocument{}, false
+	}
+	return *document, true
+}
+
+func (s *documentStore) all() []TextDocument {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	documents := make([]TextDocument, 0, len(s.documents))
+	for _, document := range s.documents {
+		documents = append(documents, *document)
+	}
+	sort.Slice(documents, func(i, j int) bool { return documents[i].URI < documents[j].URI })
+	return documents
+}
+
+func applyContentChange(text string, change protocol.TextDocumentContentChangeEvent) (string, error) {
+	if change.Range == nil {
+		return change.Text, nil
+	}
+	start, err := positionOffset(text, change.Range.Start)
+	if err != nil {
+		return "", err
+	}
+	end, err := positionOffset(text, change.Range.End)
+	if err != nil {
+		return "", err
+	}
+	if end < start {
+		return "", fmt.Errorf("range end %d:%d is before start %d:%d",
+			change.Range.End.Line, change.Range.End.Character, change.Range.Start.Line, change.Range.Start.Character)
+	}
+	return text[:start] + change.Text + text[end:], nil
+}
+
+// positionOffset converts an LSP position, whose character is counted in UTF-16
+// code units, into a byte offset in text. A character past the end of the line
+// resolves to the end of the line as the specification requires.
+func positionOffset(text string, position protocol.Position) (int, error) {
+	offset := 0
+	for line := uint32(0); line < position.Line; line++ {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return 0, fmt.Errorf("line %d is out of range", position.Line)
+		}
+		offset += i + 1
+	}
+
+	lineEnd := len(text)
+	if i := strings.IndexByte(text[offset:], '\n'); i >= 0 {
+		lineEnd = offset + i
+	}
+	if lineEnd > offset && text[lineEnd-1] == '\r' {
+		lineEnd--
+	}
+
+	units := uint32(0)
+	for offset < lineEnd && units < position.Character {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		units += uint32(len(utf16.Encode([]rune{r})))
+		offset += size
+	}
+	return offset, nil
+}
+
+// offsetPosition is the inverse of positionOffset.
+func offsetPosition(text string, offset int) protocol.Position {
+	if offset > len(text) {
+		offset = len(text)
+	}
+	position := protocol.Position{}
+	lineStart := strings.LastIndexByte(text[:offset], '\n') + 1
+	position.Line = uint32(strings.Count(text[:offset], "\n"))
+	for _, r := range text[lineStart:offset] {
+		position.Character += uint32(len(utf16.Encode([]rune{r})))
+	}
+	return position
+}
+
+func textDocumentSyncKind(capabilities protocol.ServerCapabilities) protocol.TextDocumentSyncKind {
+	switch sync := capabilities.TextDocumentSync.(type) {
+	case float64:
+		return protocol.TextDocumentSyncKind(sync)
+	case map[string]interface{}:
+		if change, ok := sync["change"].(float64); ok {
+			return protocol.TextDocumentSyncKind(change)
+		}
+	}
+	return protocol.None
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"lsp/protocol"
+	"testing"
+)
+
+func TestPositionOffset(t *testing.T) {
+	text := "héllo\r\n𝒳y\nend"
+	tests := []struct {
+		line      uint32
+		character uint32
+		offset    int
+	}{
+		{0, 0, 0},
+		{0, 2, 3},
+		{0, 5, 6},
+		{0, 9, 6},
+		{1, 0, 8},
+		{1, 1, 12},
+		{1, 2, 12},
+		{1, 3, 13},
+		{2, 3, 17},
+		{2, 10, 17},
+		{3, 0, -1},
+	}
+	for _, test := range tests {
+		offset, err := positionOffset(text, protocol.Position{Line: test.line, Character: test.character})
+		if test.offset < 0 {
+			if err == nil {
+				t.Errorf("positionOffset(%d:%d) = %d, want an error", test.line, test.character, offset)
+			}
+			continue
+		}
+		if err != nil || offset != test.offset {
+			t.Errorf("positionOffset(%d:%d) = %d, %v, want %d", test.line, test.character, offset, err, test.offset)
+		}
+	}
+
+	offset, err := positionOffset("abc\n", protocol.Position{Line: 1})
+	if err != nil || offset != 4 {
+		t.Errorf("positionOffset of the empty last line = %d, %v, want 4", offset, err)
+	}
+}
+
+func TestOffsetPosition(t *testing.T) {
+	text := "héllo\n𝒳y\nend"
+	for _, offset := range []int{0, 3, 6, 7, 11, 12, 13, 16} {
+		position := offsetPosition(text, offset)
+		got, err := positionOffset(text, position)
+		if err != nil || got != offset {
+			t.Errorf("positionOffset(offsetPosition(%d) = %d:%d) = %d, %v", offset, position.Line, position.Character, got, err)
+		}
+	}
+}
+
+func TestApplyContentChange(t *testing.T) {
+	text := "héllo\r\n𝒳y\nend"
+	change := func(l1, c1, l2, c2 uint32, text string) protocol.TextDocumentContentChangeEvent {
+		edit := textEdit(l1, c1, l2, c2, text)
+		return protocol.TextDocumentContentChangeEvent{Range: &edit.Range, Text: edit.NewText}
+	}
+	tests := []struct {
+		name   string
+		change protocol.TextDocumentContentChangeEvent
+		want   string
+	}{
+		{"multi-byte character", change(0, 1, 0, 2, "e"), "hello\r\n𝒳y\nend"},
+		{"end of a CRLF line", change(0, 5, 0, 5, "!"), "héllo!\r\n𝒳y\nend"},
+		{"past the end of a CRLF line", change(0, 8, 0, 8, "!"), "héllo!\r\n𝒳y\nend"},
+		{"join CRLF lines", change(0, 5, 1, 0, " "), "héllo 𝒳y\nend"},
+		{"surrogate pair", change(1, 0, 1, 2, "X"), "héllo\r\nXy\nend"},
+		{"end of document", change(2, 3, 2, 3, "\n"), "héllo\r\n𝒳y\nend\n"},
+		{"whole document", protocol.TextDocumentContentChangeEvent{Text: "new"}, "new"},
+		{"end before start", change(1, 2, 1, 0, ""), ""},
+		{"line out of range", change(5, 0, 5, 0, "x"), ""},
+	}
+	for _, test := range tests {
+		got, err := applyContentChange(text, test.change)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%s: applyContentChange = %q, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%s: applyContentChange = %q, %v, want %q", test.name, got, err, test.want)
+		}
+	}
+}
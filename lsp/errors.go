@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// CodeContentModified is the LSP error code for a request whose result a document change made
+// stale. The requests in retryOnContentModified are sent again when they get it.
+const CodeContentModified = -32801
+
+const maxContentModifiedRetries = 3
+
+var retryOnContentModified = []string{
+	"textDocument/semanticTokens/full",
+	"textDocument/semanticTokens/full/delta",
+	"textDocument/semanticTokens/range",
+	"textDocument/foldingRange",
+	"textDocument/documentSymbol",
+	"textDocument/codeLens",
+	"textDocument/selectionRange",
+}
+
+// TransportError means the message never made it to the server or the reply never came back.
+type TransportError struct {
+	Method string
+	Err    error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("lsp %s: transport failed: %s", e.Method, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// RPCError is a JSON-RPC protocol level failure such as MethodNotFound or InvalidParams.
+// InternalError is not one of them, servers answer failed requests with it.
+type RPCError struct {
+	Method  string
+	Code    int64
+	Message string
+	Data    *json.RawMessage
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("lsp %s: json-rpc error %d: %s", e.Method, e.Code, e.Message)
+}
+
+// ResponseError is an error reported by the language server while handling a well formed request.
+type ResponseError struct {
+	Method  string
+	Code    int64
+	Message string
+	Data    *json.RawMessage
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("lsp %s: server error %d: %s", e.Method, e.Code, e.Message)
+}
+
+func wrapCallError(method string, err error) error {
+	var rpcErr *jsonrpc2.Error
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.Code {
+		case jsonrpc2.CodeParseError, jsonrpc2.CodeInvalidRequest, jsonrpc2.CodeMethodNotFound,
+			jsonrpc2.CodeInvalidParams:
+			return &RPCError{Method: method, Code: rpcErr.Code, Message: rpcErr.Message, Data: rpcErr.Data}
+		default:
+			return &ResponseError{Method: method, Code: rpcErr.Code, Message: rpcErr.Message, Data: rpcErr.Data}
+		}
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("lsp %s: %w", method, err)
+	}
+	return &TransportError{Method: method, Err: err}
+}
+
+// shouldRetry reports whether a failed request is sent again because the content it was
+// computed for changed while the server worked on it.
+func shouldRetry(method string, err error, attempt int) bool {
+	var responseErr *ResponseError
+	if attempt >= maxContentModifiedRetries || !errors.As(err, &responseErr) || responseErr.Code != CodeContentModified {
+		return false
+	}
+	for _, m := range retryOnContentModified {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func isNullResult(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
+
+func isClosedError(err error) bool {
+	return errors.Is(err, jsonrpc2.ErrClosed)
+}
+
+// isServerUnavailable tells apart errors that mean no server could answer from errors the
+// server answered with.
+func isServerUnavailable(err error) bool {
+	var transportErr *TransportError
+	var stateErr *StateError
+	var unsupportedErr *UnsupportedMethodError
+	return errors.As(err, &transportErr) || errors.As(err, &stateErr) || errors.As(err, &unsupportedErr) ||
+		errors.Is(err, ErrConnectionLost)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sourcegraph/jsonrpc2"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWrapCallError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&jsonrpc2.Error{Code: jsonrpc2.CodeParseError}, "rpc"},
+		{&jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest}, "rpc"},
+		{&jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound}, "rpc"},
+		{&jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}, "rpc"},
+		{fmt.Errorf("call: %w", &jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound}), "rpc"},
+		{&jsonrpc2.Error{Code: jsonrpc2.CodeInternalError}, "response"},
+		{&jsonrpc2.Error{Code: CodeContentModified}, "response"},
+		{&jsonrpc2.Error{Code: -32803}, "response"},
+		{io.ErrUnexpectedEOF, "transport"},
+		{jsonrpc2.ErrClosed, "transport"},
+		{context.Canceled, "context"},
+		{context.DeadlineExceeded, "context"},
+	}
+	for _, test := range tests {
+		err := wrapCallError("textDocument/hover", test.err)
+		var rpcErr *RPCError
+		var responseErr *ResponseError
+		var transportErr *TransportError
+		got := "context"
+		switch {
+		case errors.As(err, &rpcErr):
+			got = "rpc"
+		case errors.As(err, &responseErr):
+			got = "response"
+		case errors.As(err, &transportErr):
+			got = "transport"
+		}
+		if got != test.want {
+			t.Errorf("wrapCallError(%v) = %T, want a %s error", test.err, err, test.want)
+		}
+		if got == "context" && !errors.Is(err, test.err) {
+			t.Errorf("wrapCallError(%v) = %v, does not wrap the context error", test.err, err)
+		}
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	contentModified := &ResponseError{Code: CodeContentModified}
+	tests := []struct {
+		method  string
+		err     error
+		attempt int
+		want    bool
+	}{
+		{"textDocument/foldingRange", contentModified, 0, true},
+		{"textDocument/foldingRange", contentModified, maxContentModifiedRetries - 1, true},
+		{"textDocument/foldingRange", contentModified, maxContentModifiedRetries, false},
+		{"textDocument/semanticTokens/full/delta", fmt.Errorf("wrapped: %w", contentModified), 1, true},
+		{"textDocument/hover", contentModified, 0, false},
+		{"textDocument/foldingRange", &ResponseError{Code: jsonrpc2.CodeInternalError}, 0, false},
+		{"textDocument/foldingRange", &RPCError{Code: CodeContentModified}, 0, false},
+		{"textDocument/foldingRange", &TransportError{Err: io.EOF}, 0, false},
+	}
+	for _, test := range tests {
+		if got := shouldRetry(test.method, test.err, test.attempt); got != test.want {
+			t.Errorf("shouldRetry(%s, %v, %d) = %v, want %v", test.method, test.err, test.attempt, got, test.want)
+		}
+	}
+}
+
+// TestCallContentModified runs call against a server that answers ContentModified until
+// it has been asked succeedAfter times.
+func TestCallContentModified(t *testing.T) {
+	tests := []struct {
+		method       string
+		succeedAfter int32
+		calls        int32
+		ok           bool
+	}{
+		{"textDocument/foldingRange", 3, 3, true},
+		{"textDocument/foldingRange", 100, 1 + maxContentModifiedRetries, false},
+		{"textDocument/hover", 2, 1, false},
+	}
+	for _, test := range tests {
+		var calls int32
+		lsp := newTestLanguageServer()
+		connectTestServer(t, lsp, `{"foldingRangeProvider":true,"hoverProvider":true}`, func(request *jsonrpc2.Request) (interface{}, error) {
+			if atomic.AddInt32(&calls, 1) < test.succeedAfter {
+				return nil, &jsonrpc2.Error{Code: CodeContentModified, Message: "content modified"}
+			}
+			return []int{}, nil
+		})
+		var result []int
+		err := lsp.call(context.Background(), test.method, struct{}{}, &result)
+		if got := atomic.LoadInt32(&calls); (err == nil) != test.ok || got != test.calls {
+			t.Errorf("%s answered after %d calls: call = %v after %d calls, want %d calls", test.method, test.succeedAfter, err, got, test.calls)
+		}
+		var responseErr *ResponseError
+		if err != nil && (!errors.As(err, &responseErr) || responseErr.Code != CodeContentModified) {
+			t.Errorf("%s: call = %v, want a ContentModified ResponseError", test.method, err)
+		}
+	}
+}
+
+// connectTestServer connects lsp, as an initialized client with the capabilities, to a
+// server that answers every request with handle.
+func connectTestServer(t *testing.T, lsp *LanguageServer, capabilities string, handle func(request *jsonrpc2.Request) (interface{}, error)) {
+	t.Helper()
+	serverSide, clientSide := net.Pipe()
+	server := jsonrpc2.NewConn(context.Background(), jsonrpc2.NewBufferedStream(serverSide, jsonrpc2.VSCodeObjectCodec{}), jsonrpc2.HandlerWithError(func(ctx context.Context, conn *jsonrpc2.Conn, request *jsonrpc2.Request) (interface{}, error) {
+		return handle(request)
+	}))
+	client := jsonrpc2.NewConn(context.Background(), jsonrpc2.NewBufferedStream(clientSide, jsonrpc2.VSCodeObjectCodec{}), jsonrpc2.HandlerWithError(func(context.Context, *jsonrpc2.Conn, *jsonrpc2.Request) (interface{}, error) {
+		return nil, nil
+	}))
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	var err error
+	_, lsp.capabilities, err = decodeInitializeResult(json.RawMessage(`{"capabilities":` + capabilities + `}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lsp.state = StateInitialized
+	lsp.rpcConn = client
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"lsp/protocol"
+	"sort"
+)
+
+// FoldingRange is protocol.FoldingRange with the optional characters kept apart from 0.
+// Without them the whole start and end lines are folded.
+type FoldingRange struct {
+	StartLine      uint32                    `json:"startLine"`
+	StartCharacter *uint32                   `json:"startCharacter,omitempty"`
+	EndLine        uint32                    `json:"endLine"`
+	EndCharacter   *uint32                   `json:"endCharacter,omitempty"`
+	Kind           protocol.FoldingRangeKind `json:"kind,omitempty"`
+}
+
+// FoldingRanges returns the folding ranges of a document ordered by start line, only those
+// of the given kinds (protocol.Imports, protocol.Comment, protocol.Region) if any are given.
+func (lsp *LanguageServer) FoldingRanges(ctx context.Context, uri string, kinds ...protocol.FoldingRangeKind) ([]FoldingRange, error) {
+	foldingRangeParams := protocol.FoldingRangeParams{}
+	foldingRangeParams.TextDocument.URI = protocol.DocumentURI(uri)
+
+	var result []FoldingRange
+	err := lsp.call(ctx, "textDocument/foldingRange", &foldingRangeParams, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	foldingRanges := make([]FoldingRange, 0, len(result))
+	for _, foldingRange := range result {
+		if foldingRange.EndLine < foldingRange.StartLine {
+			continue
+		}
+		if len(kinds) > 0 && !hasFoldingRangeKind(kinds, foldingRange.Kind) {
+			continue
+		}
+		foldingRanges = append(foldingRanges, foldingRange)
+	}
+	sort.SliceStable(foldingRanges, func(i, j int) bool {
+		if foldingRanges[i].StartLine != foldingRanges[j].StartLine {
+			return foldingRanges[i].StartLine < foldingRanges[j].StartLine
+		}
+		return foldingRanges[i].EndLine > foldingRanges[j].EndLine
+	})
+	return foldingRanges, nil
+}
+
+func hasFoldingRangeKind(kinds []protocol.FoldingRangeKind, kind protocol.FoldingRangeKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/format"
+	"lsp/protocol"
+	"strings"
+)
+
+// maxDiffCells bounds the line diff table, larger changes become one edit.
+const maxDiffCells = 4 << 20
+
+// Format formats an open document and applies the edits to the document store. Go files
+// are formatted with go/format when the server cannot do it.
+func (lsp *LanguageServer) Format(ctx context.Context, uri string, options protocol.FormattingOptions) ([]protocol.TextEdit, error) {
+	formattingParams := protocol.DocumentFormattingParams{Options: options}
+	formattingParams.TextDocument.URI = protocol.DocumentURI(uri)
+	return lsp.format(ctx, uri, "textDocument/formatting", &formattingParams, nil)
+}
+
+func (lsp *LanguageServer) RangeFormat(ctx context.Context, uri string, formatRange protocol.Range, options protocol.FormattingOptions) ([]protocol.TextEdit, error) {
+	rangeFormattingParams := protocol.DocumentRangeFormattingParams{Range: formatRange, Options: options}
+	rangeFormattingParams.TextDocument.URI = protocol.DocumentURI(uri)
+	return lsp.format(ctx, uri, "textDocument/rangeFormatting", &rangeFormattingParams, &formatRange)
+}
+
+// OnTypeFormat formats after ch was typed at position. The fallback only touches the line
+// of the position and the one before it.
+func (lsp *LanguageServer) OnTypeFormat(ctx context.Context, uri string, position protocol.Position, ch string, options protocol.FormattingOptions) ([]protocol.TextEdit, error) {
+	onTypeFormattingParams := protocol.DocumentOnTypeFormattingParams{Position: position, Ch: ch, Options: options}
+	onTypeFormattingParams.TextDocument.URI = protocol.DocumentURI(uri)
+
+	formatRange := protocol.Range{Start: protocol.Position{Line: position.Line}, End: protocol.Position{Line: position.Line + 1}}
+	if position.Line > 0 {
+		formatRange.Start.Line--
+	}
+	return lsp.format(ctx, uri, "textDocument/onTypeFormatting", &onTypeFormattingParams, &formatRange)
+}
+
+func (lsp *LanguageServer) format(ctx context.Context, uri, method string, params interface{}, fallbackRange *protocol.Range) ([]protocol.TextEdit, error) {
+	document, ok := lsp.documents.get(protocol.DocumentURI(uri))
+	if !ok {
+		return nil, fmt.Errorf("lsp %s: document %s is not open", method, uri)
+	}
+
+	var edits []protocol.TextEdit
+	err := lsp.call(ctx, method, params, &edits)
+	fallback := isServerUnavailable(err) && isGoDocument(document)
+	if err != nil && !fallback {
+		return nil, err
+	}
+	if fallback {
+		log.Warnf("LanguageServer %s unavailable, format with go/format. uri:%s, err: %s", method, uri, err)
+		edits, err = formatGoSource(document.Text, fallbackRange)
+		if err != nil {
+			return nil, fmt.Errorf("lsp %s: %w", method, err)
+		}
+	}
+	if len(edits) == 0 {
+		return []protocol.TextEdit{}, nil
+	}
+
+	// The edits are for the text they were computed on, a document the editor changed in the
+	// meantime is left alone. The store is updated before the server is told, so a server
+	// that is away picks the formatted text up when the session is restored.
+	lsp.documentMutex.Lock()
+	defer lsp.documentMutex.Unlock()
+	current, ok := lsp.documents.get(document.URI)
+	if !ok || current.Version != document.Version {
+		return nil, fmt.Errorf("lsp %s: document %s changed while it was formatted", method, uri)
+	}
+	err = lsp.didChangeLocked(ctx, uri, textEditsToChanges(edits))
+	if err != nil && !(fallback && isServerUnavailable(err)) {
+		return nil, err
+	}
+	return edits, nil
+}
+
+func isGoDocument(document TextDocument) bool {
+	return document.LanguageID == "go" || strings.HasSuffix(string(document.URI), ".go")
+}
+
+// formatGoSource runs go/format and returns the difference as line edits, only those
+// inside formatRange if it is given.
+func formatGoSource(text string, formatRange *protocol.Range) ([]protocol.TextEdit, error) {
+	formatted, err := format.Source([]byte(text))
+	if err != nil {
+		return nil, fmt.Errorf("go/format failed: %w", err)
+	}
+	edits := diffTextEdits(text, string(formatted))
+	if formatRange == nil {
+		return edits, nil
+	}
+	inRange := make([]protocol.TextEdit, 0, len(edits))
+	for _, edit := range edits {
+		if comparePosition(edit.Range.Start, formatRange.Start) >= 0 && comparePosition(edit.Range.End, formatRange.End) <= 0 {
+			inRange = append(inRange, edit)
+		}
+	}
+	return inRange, nil
+}
+
+// diffTextEdits turns the line diff between before and after into whole line TextEdits.
+func diffTextEdits(before, after string) []protocol.TextEdit {
+	a, b := splitLines(before), splitLines(after)
+	offsets := make([]int, len(a)+1)
+	for i, line := range a {
+		offsets[i+1] = offsets[i] + len(line)
+	}
+	edit := func(aStart, aEnd, bStart, bEnd int) protocol.TextEdit {
+		return protocol.TextEdit{
+			Range: protocol.Range{
+				Start: offsetPosition(before, offsets[aStart]),
+				End:   offsetPosition(before, offsets[aEnd]),
+			},
+			NewText: strings.Join(b[bStart:bEnd], ""),
+		}
+	}
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	aLines, bLines := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(aLines) == 0 && len(bLines) == 0 {
+		return []protocol.TextEdit{}
+	}
+	if len(aLines)*len(bLines) > maxDiffCells {
+		return []protocol.TextEdit{edit(prefix, len(a)-suffix, prefix, len(b)-suffix)}
+	}
+
+	// lcs[i][j] is the longest common subsequence of aLines[i:] and bLines[j:].
+	lcs := make([][]int32, len(aLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(bLines)+1)
+	}
+	for i := len(aLines) - 1; i >= 0; i-- {
+		for j := len(bLines) - 1; j >= 0; j-- {
+			switch {
+			case aLines[i] == bLines[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	edits := []protocol.TextEdit{}
+	i, j := 0, 0
+	for i < len(aLines) || j < len(bLines) {
+		if i < len(aLines) && j < len(bLines) && aLines[i] == bLines[j] {
+			i++
+			j++
+			continue
+		}
+		startI, startJ := i, j
+		for i < len(aLines) || j < len(bLines) {
+			if i < len(aLines) && j < len(bLines) && aLines[i] == bLines[j] {
+				break
+			}
+			if j == len(bLines) || (i < len(aLines) && lcs[i+1][j] >= lcs[i][j+1]) {
+				i++
+			} else {
+				j++
+			}
+		}
+		edits = append(edits, edit(prefix+startI, prefix+i, prefix+startJ, prefix+j))
+	}
+	return edits
+}
+
+// splitLines keeps the line endings, so joining the lines gives the text back.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func applyDiff(t *testing.T, before, after string) int {
+	t.Helper()
+	edits := diffTextEdits(before, after)
+	text := before
+	for _, change := range textEditsToChanges(edits) {
+		var err error
+		text, err = applyContentChange(text, change)
+		if err != nil {
+			t.Fatalf("apply diff of %q to %q: %s", before, after, err)
+		}
+	}
+	if text != after {
+		t.Fatalf("diff of %q to %q gives %q", before, after, text)
+	}
+	return len(edits)
+}
+
+func TestDiffTextEdits(t *testing.T) {
+	tests := []struct {
+		before string
+		after  string
+		edits  int
+	}{
+		{"", "", 0},
+		{"a\nb\n", "a\nb\n", 0},
+		{"", "package main\n", 1},
+		{"package main\n", "", 1},
+		{"a\nb\nc\n", "a\nx\nc\n", 1},
+		{"a\nb\nc\nd\ne\n", "a\nB\nc\nd\nE\n", 2},
+		{"a\nc\n", "a\nb\nc\n", 1},
+		{"a\nb\nc\n", "a\nc\n", 1},
+		{"a\nb", "a\nb\n", 1},
+		{"func  main(){\r\n\tx:=1\r\n}\r\n", "func main() {\r\n\tx := 1\r\n}\r\n", 1},
+		{"// héllo 𝒳\nvar  x = \"𝒳\"\n// end\n", "// héllo 𝒳\nvar x = \"𝒳\"\n// end\n", 1},
+		{"b\na\nb\na\n", "a\nb\na\nb\n", 2},
+	}
+	for _, test := range tests {
+		if edits := applyDiff(t, test.before, test.after); edits != test.edits {
+			t.Errorf("diff of %q to %q has %d edits, want %d", test.before, test.after, edits, test.edits)
+		}
+	}
+}
+
+func TestDiffTextEditsLimit(t *testing.T) {
+	var before, after strings.Builder
+	before.WriteString("package main\n")
+	after.WriteString("package main\n")
+	// Every other line changes, below the limit that would be an edit per changed line.
+	for i := 0; i*i <= maxDiffCells || i%2 == 1; i++ {
+		fmt.Fprintf(&before, "var a%d = %d\n", i, i)
+		if i%2 == 0 {
+			fmt.Fprintf(&after, "var b%d = %d\n", i, i)
+		} else {
+			fmt.Fprintf(&after, "var a%d = %d\n", i, i)
+		}
+	}
+	before.WriteString("// end\n")
+	after.WriteString("// end\n")
+	if edits := applyDiff(t, before.String(), after.String()); edits != 1 {
+		t.Fatalf("diff over the limit has %d edits, want 1", edits)
+	}
+}
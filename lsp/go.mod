@@ -5,6 +5,7 @@ go 1.16
 require (
 	github.com/BurntSushi/toml v0.4.1 // indirect
 	github.com/bittygarden/lilac v1.1.11
+	github.com/gorilla/websocket v1.4.1
 	github.com/kr/pretty v0.1.0
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/sourcegraph/jsonrpc2 v0.1.0
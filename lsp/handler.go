@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sourcegraph/jsonrpc2"
+	"lsp/protocol"
+)
+
+type LSPHandler struct {
+	server *LanguageServer
+}
+
+func (l *LSPHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, request *jsonrpc2.Request) {
+	if !request.Notif {
+		l.handleRequest(ctx, conn, request)
+		return
+	}
+
+	switch request.Method {
+	case "textDocument/publishDiagnostics":
+		l.handlePublishDiagnostics(request)
+	case "window/logMessage", "window/showMessage":
+		l.handleMessage(request)
+	case "$/progress":
+		l.handleProgress(request)
+	default:
+		log.Infof("LSPHandler unhandled notification method:%s", request.Method)
+	}
+}
+
+// handleRequest answers a server to client request. Every request gets exactly one reply.
+// workspace/applyEdit is answered from its own goroutine, its file I/O and lock waits must
+// not hold up the read loop and with it the replies to our own requests.
+func (l *LSPHandler) handleRequest(ctx context.Context, conn *jsonrpc2.Conn, request *jsonrpc2.Request) {
+	var result interface{}
+	var respErr *jsonrpc2.Error
+	switch request.Method {
+	case "workspace/configuration":
+		result, respErr = l.handleConfiguration(request)
+	case "workspace/workspaceFolders":
+		result = l.server.WorkspaceFolders()
+	case "client/registerCapability":
+		result, respErr = l.handleRegisterCapability(request)
+	case "client/unregisterCapability":
+		result, respErr = l.handleUnregisterCapability(request)
+	case "window/workDoneProgress/create":
+		result, respErr = l.handleWorkDoneProgressCreate(request)
+	case "window/showMessageRequest":
+		result, respErr = l.handleShowMessageRequest(request)
+	case "workspace/applyEdit":
+		go func() {
+			result, respErr := l.handleApplyEdit(ctx, request)
+			l.reply(ctx, conn, request, result, respErr)
+		}()
+		return
+	case "workspace/semanticTokens/refresh":
+		// Result ids from before the refresh must not be used for deltas.
+		l.server.semanticTokens.reset()
+	case "workspace/codeLens/refresh":
+		l.server.codeLenses.refresh()
+	default:
+		respErr = &jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound, Message: "method not found: " + request.Method}
+	}
+	l.reply(ctx, conn, request, result, respErr)
+}
+
+func (l *LSPHandler) reply(ctx context.Context, conn *jsonrpc2.Conn, request *jsonrpc2.Request, result interface{}, respErr *jsonrpc2.Error) {
+	var err error
+	if respErr != nil {
+		log.Warnf("LSPHandler reply error. method:%s, err: %s", request.Method, respErr)
+		err = conn.ReplyWithError(ctx, request.ID, respErr)
+	} else {
+		err = conn.Reply(ctx, request.ID, result)
+	}
+	if err != nil {
+		log.Errorf("LSPHandler reply failed. method:%s, err: %s", request.Method, err)
+	}
+}
+
+func (l *LSPHandler) handleConfiguration(request *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+	params := protocol.ConfigurationParams{}
+	if respErr := decodeRequestParams(request, &params); respErr != nil {
+		return nil, respErr
+	}
+	result := make([]interface{}, 0, len(params.Items))
+	for _, item := range params.Items {
+		result = append(result, l.server.configuration(item.Section))
+	}
+	return result, nil
+}
+
+func (l *LSPHandler) handleRegisterCapability(request *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+	params := protocol.RegistrationParams{}
+	if respErr := decodeRequestParams(request, &params); respErr != nil {
+		return nil, respErr
+	}
+	l.server.register(params.Registrations)
+	return nil, nil
+}
+
+func (l *LSPHandler) handleUnregisterCapability(request *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+	params := protocol.UnregistrationParams{}
+	if respErr := decodeRequestParams(request, &params); respErr != nil {
+		return nil, respErr
+	}
+	l.server.unregister(params.Unregisterations)
+	return nil, nil
+}
+
+func (l *LSPHandler) handleWorkDoneProgressCreate(request *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+	params := protocol.WorkDoneProgressCreateParams{}
+	if respErr := decodeRequestParams(request, &params); respErr != nil {
+		return nil, respErr
+	}
+	log.Infof("LSPHandler workDoneProgress create. token:%v", params.Token)
+	return nil, nil
+}
+
+// handleShowMessageRequest logs the message. There is no user to pick an action, so none is chosen.
+func (l *LSPHandler) handleShowMessageRequest(request *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+	params := protocol.ShowMessageRequestParams{}
+	if respErr := decodeRequestParams(request, &params); respErr != nil {
+		return nil, respErr
+	}
+	log.Infof("LSPHandler showMessageRequest. type:%v, message:%s", params.Type, params.Message)
+	return nil, nil
+}
+
+func (l *LSPHandler) handleApplyEdit(ctx context.Context, request *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+	params := struct {
+		Label string        `json:"label,omitempty"`
+		Edit  WorkspaceEdit `json:"edit"`
+	}{}
+	if respErr := decodeRequestParams(request, &params); respErr != nil {
+		return nil, respErr
+	}
+	// protocol.ApplyWorkspaceEditResponse would drop a failedChange of 0.
+	response := struct {
+		Applied       bool    `json:"applied"`
+		FailureReason string  `json:"failureReason,omitempty"`
+		FailedChange  *uint32 `json:"failedChange,omitempty"`
+	}{Applied: true}
+	err := l.server.ApplyWorkspaceEdit(ctx, params.Edit)
+	if err != nil {
+		log.Errorf("LSPHandler applyEdit failed. label:%s, err: %s", params.Label, err)
+		response.Applied = false
+		response.FailureReason = err.Error()
+		var editErr *WorkspaceEditError
+		if errors.As(err, &editErr) {
+			failedChange := uint32(editErr.FailedChange)
+			response.FailedChange = &failedChange
+		}
+	}
+	return response, nil
+}
+
+func (l *LSPHandler) handleProgress(request *jsonrpc2.Request) {
+	params := protocol.ProgressParams{}
+	if !decodeParams(request, &params) {
+		return
+	}
+	value, _ := params.Value.(map[string]interface{})
+	log.Infof("LSPHandler progress. token:%v, kind:%v, title:%v, message:%v", params.Token, value["kind"], value["title"], value["message"])
+}
+
+func (l *LSPHandler) handlePublishDiagnostics(request *jsonrpc2.Request) {
+	params := protocol.PublishDiagnosticsParams{}
+	if !decodeParams(request, &params) {
+		return
+	}
+	if l.server.diagnostics.publish(params) {
+		log.Infof("LSPHandler publishDiagnostics. uri:%s, version:%d, count:%d", params.URI, params.Version, len(params.Diagnostics))
+	}
+}
+
+func (l *LSPHandler) handleMessage(request *jsonrpc2.Request) {
+	params := protocol.ShowMessageParams{}
+	if !decodeParams(request, &params) {
+		return
+	}
+	switch params.Type {
+	case protocol.Error:
+		log.Errorf("method:%s, message:%s", request.Method, params.Message)
+	case protocol.Warning:
+		log.Warnf("method:%s, message:%s", request.Method, params.Message)
+	default:
+		log.Infof("method:%s, message:%s", request.Method, params.Message)
+	}
+}
+
+func decodeParams(request *jsonrpc2.Request, params interface{}) bool {
+	if request.Params == nil {
+		log.Errorf("LSPHandler method:%s has no params", request.Method)
+		return false
+	}
+	err := json.Unmarshal(*request.Params, params)
+	if err != nil {
+		log.Errorf("LSPHandler decode params failed. method:%s, err: %s", request.Method, err)
+		return false
+	}
+	return true
+}
+
+func decodeRequestParams(request *jsonrpc2.Request, params interface{}) *jsonrpc2.Error {
+	if !decodeParams(request, params) {
+		return &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: "invalid params for " + request.Method}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const defaultShutdownTimeout = 5 * time.Second
+
+type ServerState int
+
+const (
+	StateCreated ServerState = iota
+	StateStarted
+	StateInitializing
+	StateInitialized
+	StateShuttingDown
+	StateExited
+)
+
+func (s ServerState) String() string {
+	switch s {
+	case StateCreated:
+		return "created"
+	case StateStarted:
+		return "started"
+	case StateInitializing:
+		return "initializing"
+	case StateInitialized:
+		return "initialized"
+	case StateShuttingDown:
+		return "shutting down"
+	case StateExited:
+		return "exited"
+	default:
+		return fmt.Sprintf("ServerState(%d)", int(s))
+	}
+}
+
+var ErrConnectionLost = errors.New("language server connection lost")
+
+// StateError is returned when a message is not allowed in the current lifecycle state,
+// for example a completion request before initialize finished.
+type StateError struct {
+	Method string
+	State  ServerState
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("lsp %s: not allowed while language server is %s", e.Method, e.State)
+}
+
+// methodAllowed tells which messages may be sent in each lifecycle state.
+func methodAllowed(method string, state ServerState) bool {
+	switch state {
+	case StateStarted:
+		return method == "initialize" || method == "exit"
+	case StateInitializing:
+		return method == "initialize" || method == "initialized" || method == "exit"
+	case StateInitialized:
+		return method != "initialize"
+	case StateShuttingDown:
+		return method == "shutdown" || method == "exit" || method == "$/cancelRequest"
+	default:
+		return false
+	}
+}
+
+func (lsp *LanguageServer) State() ServerState {
+	lsp.mutex.Lock()
+	defer lsp.mutex.Unlock()
+	return lsp.state
+}
+
+// Done is closed once the server has exited, gracefully or not.
+func (lsp *LanguageServer) Done() <-chan struct{} {
+	return lsp.done
+}
+
+// Err is nil after a graceful shutdown and the cause otherwise. It is only meaningful after Done is closed.
+func (lsp *LanguageServer) Err() error {
+	lsp.mutex.Lock()
+	defer lsp.mutex.Unlock()
+	return lsp.err
+}
+
+func (lsp *LanguageServer) checkState(method string) error {
+	lsp.mutex.Lock()
+	defer lsp.mutex.Unlock()
+	if !methodAllowed(method, lsp.state) {
+		return &StateError{Method: method, State: lsp.state}
+	}
+	return nil
+}
+
+// transition moves from one of the given states to the next one.
+func (lsp *LanguageServer) transition(to ServerState, from ...ServerState) error {
+	lsp.mutex.Lock()
+	defer lsp.mutex.Unlock()
+	return lsp.transitionLocked(to, from...)
+}
+
+func (lsp *LanguageServer) transitionLocked(to ServerState, from ...ServerState) error {
+	for _, state := range from {
+		if lsp.state == state {
+			log.Infof("LanguageServer state %s -> %s", lsp.state, to)
+			lsp.state = to
+			return nil
+		}
+	}
+	return fmt.Errorf("language server cannot go from %s to %s", lsp.state, to)
+}
+
+// exitLocked records why the server stopped and wakes up everyone waiting on Done.
+func (lsp *LanguageServer) exitLocked(err error) {
+	if lsp.state == StateExited {
+		return
+	}
+	log.Infof("LanguageServer state %s -> %s", lsp.state, StateExited)
+	lsp.state = StateExited
+	lsp.err = err
+	close(lsp.done)
+}
+
+// Shutdown asks the server to shut down, tells it to exit and closes the connection.
+// It gives up waiting for the server after ServerConfig.ShutdownTimeout.
+func (lsp *LanguageServer) Shutdown(ctx context.Context) error {
+	lsp.mutex.Lock()
+	initialized := lsp.state == StateInitialized
+	err := lsp.transitionLocked(StateShuttingDown, StateStarted, StateInitializing, StateInitialized)
+	lsp.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	log.Infof("LanguageServer Shutdown start")
+
+	timeout := lsp.serverConfig.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var shutdownErr error
+	if initialized {
+		shutdownErr = lsp.call(ctx, "shutdown", nil, nil)
+		if shutdownErr != nil {
+			log.Warnf("LanguageServer Shutdown request failed. err: %s", shutdownErr)
+		}
+	}
+	err = lsp.notify(ctx, "exit", nil)
+	if err != nil {
+		log.Warnf("LanguageServer Shutdown exit notification failed. err: %s", err)
+	}
+
+	client, err := lsp.client("exit")
+	if err == nil {
+		select {
+		case <-client.DisconnectNotify():
+		case <-ctx.Done():
+			log.Warnf("LanguageServer Shutdown server did not close the connection, close it")
+		}
+		err = client.Close()
+		if err != nil && !isClosedError(err) {
+			log.Warnf("LanguageServer Shutdown close rpc connection failed. err:%s", err)
+		}
+	}
+
+	lsp.mutex.Lock()
+	lsp.exitLocked(shutdownErr)
+	lsp.mutex.Unlock()
+	log.Infof("LanguageServer Shutdown success")
+	return shutdownErr
+}
+
+func (lsp *LanguageServer) serverListenerLoop() {
+	select {
+	case <-lsp.ctx.Done():
+		log.Infof("lsp context done")
+		err := lsp.Shutdown(context.Background())
+		if err != nil {
+			log.Warnf("LanguageServer Shutdown after context done failed. err: %s", err)
+		}
+	case <-lsp.done:
+	}
+}
@@ -3,13 +3,24 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/kr/pretty"
 	"github.com/sourcegraph/jsonrpc2"
+	"io"
 	"io/ioutil"
 	"lsp/logger"
 	"lsp/protocol"
-	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 const (
@@ -24,592 +35,766 @@ const (
 var log = logger.Get()
 
 type ServerConfig struct {
+	// Transport is TransportTCP (default) or TransportStdio.
+	Transport string
+
 	NetWork string
 	Address string
+
+	// Command, Args, Dir and Env describe the child process used by TransportStdio.
+	Command string
+	Args    []string
+	Dir     string
+	Env     []string
+
+	// RestartMinBackoff and RestartMaxBackoff bound the delay between reconnect attempts.
+	// MaxReconnectAttempts of 0 keeps trying until the server is shut down.
+	RestartMinBackoff    time.Duration
+	RestartMaxBackoff    time.Duration
+	MaxReconnectAttempts int
+	ShutdownTimeout      time.Duration
+
+	// Settings answers workspace/configuration, keyed by section such as "gopls".
+	Settings map[string]interface{}
+
+	// Client defaults to DefaultClientOptions.
+	Client *ClientOptions
 }
 
 type LanguageServer struct {
 	initialized bool
-	started     bool
+	state       ServerState
+	starting    bool
+	done        chan struct{}
+	err         error
 
 	mutex        sync.Mutex
 	ctx          context.Context
-	conn         net.Conn
+	conn         io.ReadWriteCloser
 	rpcConn      *jsonrpc2.Conn
 	serverConfig ServerConfig
+
+	transport      transport
+	restartBackoff *backoff
+	requestSeq     uint64
+
+	documents     *documentStore
+	documentMutex sync.Mutex
+	capabilities  serverCapabilities
+
+	diagnostics      *diagnosticsStore
+	semanticTokens   *semanticTokensCache
+	workspaceSymbols workspaceSymbolCache
+	codeLenses       *codeLensCache
+
+	workspaceFolders []protocol.WorkspaceFolder
+	registrations    map[string]protocol.Registration
+	settings         map[string]interface{}
+
+	connectionEvents *connectionEvents
+	documentEdits    *subscribers
 }
 
 func InitLanguageServer(ctx context.Context, config ServerConfig) *LanguageServer {
 	server := LanguageServer{}
 	server.ctx = ctx
-	server.serverConfig.NetWork = config.NetWork
-	server.serverConfig.Address = config.Address
+	server.serverConfig = config
+	server.done = make(chan struct{})
+	server.documents = newDocumentStore()
+	server.diagnostics = newDiagnosticsStore()
+	server.registrations = make(map[string]protocol.Registration)
+	server.semanticTokens = newSemanticTokensCache()
+	server.codeLenses = newCodeLensCache()
+	server.settings = config.Settings
+	server.connectionEvents = newConnectionEvents()
+	server.documentEdits = newSubscribers("document edit", false)
 	server.initialized = true
 	return &server
 }
 
-func (lsp *LanguageServer) Start() {
+func (lsp *LanguageServer) Start() error {
 	lsp.mutex.Lock()
-	defer lsp.mutex.Unlock()
-	if lsp.started {
-		log.Infof("LanguageServer start server, server have started")
-		return
+	if !lsp.initialized {
+		lsp.mutex.Unlock()
+		return errors.New("start language server failed. please init first")
+	}
+	if lsp.starting {
+		lsp.mutex.Unlock()
+		return errors.New("start language server failed. server is starting")
+	}
+	if lsp.state != StateCreated {
+		lsp.mutex.Unlock()
+		log.Infof("LanguageServer start server, server have started. state:%s", lsp.state)
+		return nil
 	}
-
-	lsp.fatalfIfNotInit()
 
 	log.Infof("LanguageServer start server")
-	conn, err := net.Dial(lsp.serverConfig.NetWork, lsp.serverConfig.Address)
+	t, err := newTransport(lsp.serverConfig)
 	if err != nil {
-		log.Fatalf("LanguageServer net dial failed. network:%s, address:%s", lsp.serverConfig.NetWork, lsp.serverConfig.Address)
+		lsp.mutex.Unlock()
+		return fmt.Errorf("create transport failed: %w", err)
 	}
+	lsp.transport = t
+	lsp.restartBackoff = newBackoff(lsp.serverConfig.RestartMinBackoff, lsp.serverConfig.RestartMaxBackoff)
+	lsp.starting = true
+	lsp.mutex.Unlock()
+
+	// Dialing or spawning the server can take long, it must not block State, calls and Shutdown.
+	conn, err := t.open(lsp.ctx)
+
+	lsp.mutex.Lock()
+	defer lsp.mutex.Unlock()
+	lsp.starting = false
+	if err != nil {
+		return fmt.Errorf("open transport %s failed: %w", t, err)
+	}
+	if lsp.state != StateCreated {
+		conn.Close()
+		return fmt.Errorf("start language server failed. state changed to %s while starting", lsp.state)
+	}
+	lsp.connect(conn)
+
+	lsp.state = StateStarted
+	go lsp.serverListenerLoop()
+
+	log.Infof("LanguageServer start success. transport:%s", lsp.transport)
+	lsp.connectionEvents.publish(ConnectionConnected, 0, nil)
+	return nil
+}
+
+func (lsp *LanguageServer) connect(conn io.ReadWriteCloser) {
 	lsp.conn = conn
 
 	stream := jsonrpc2.NewBufferedStream(conn, jsonrpc2.VSCodeObjectCodec{})
-	client := jsonrpc2.NewConn(lsp.ctx, stream, &LSPHandler{}, jsonrpc2.LogMessages(&Logger{}))
+	client := jsonrpc2.NewConn(lsp.ctx, stream, &LSPHandler{server: lsp}, jsonrpc2.LogMessages(&Logger{}))
 	lsp.rpcConn = client
 
-	go lsp.serverListenerLoop()
-
-	lsp.started = true
+	go lsp.superviseLoop(client)
+}
 
-	log.Infof("LanguageServer start success")
+func (lsp *LanguageServer) client(method string) (*jsonrpc2.Conn, error) {
+	lsp.mutex.Lock()
+	defer lsp.mutex.Unlock()
+	if lsp.rpcConn == nil {
+		return nil, &TransportError{Method: method, Err: errors.New("language server not started")}
+	}
+	return lsp.rpcConn, nil
 }
 
-func (lsp *LanguageServer) serverListenerLoop() {
-	for {
-		select {
-		case <-lsp.ctx.Done():
-			log.Infof("lsp context done")
-			lsp.Shutdown()
+func (lsp *LanguageServer) call(ctx context.Context, method string, params, result interface{}) error {
+	err := checkMethodKind(method, methodRequest)
+	if err != nil {
+		return err
+	}
+	err = lsp.checkState(method)
+	if err != nil {
+		return err
+	}
+	if !lsp.Supports(method) {
+		return &UnsupportedMethodError{Method: method}
+	}
+	client, err := lsp.client(method)
+	if err != nil {
+		return err
+	}
+
+	var raw json.RawMessage
+	for attempt := 0; ; attempt++ {
+		id := jsonrpc2.ID{Num: atomic.AddUint64(&lsp.requestSeq, 1)}
+		err = client.Call(ctx, method, params, &raw, jsonrpc2.PickID(id))
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			lsp.cancelRequest(client, id)
 		}
+		err = wrapCallError(method, err)
+		if !shouldRetry(method, err, attempt) {
+			return err
+		}
+		log.Infof("LanguageServer %s content modified, retry it. attempt:%d", method, attempt+1)
+	}
+	if result == nil || isNullResult(raw) {
+		return nil
+	}
+	err = json.Unmarshal(raw, result)
+	if err != nil {
+		return fmt.Errorf("lsp %s: decode result failed: %w", method, err)
+	}
+	return nil
+}
+
+func (lsp *LanguageServer) notify(ctx context.Context, method string, params interface{}) error {
+	err := checkMethodKind(method, methodNotification)
+	if err != nil {
+		return err
+	}
+	err = lsp.checkState(method)
+	if err != nil {
+		return err
+	}
+	client, err := lsp.client(method)
+	if err != nil {
+		return err
 	}
+
+	err = client.Notify(ctx, method, params)
+	if err != nil {
+		return wrapCallError(method, err)
+	}
+	return nil
 }
 
-func (lsp *LanguageServer) Shutdown() {
-	err := lsp.rpcConn.Close()
+// cancelRequest tells the server to stop working on a request whose caller has gone away.
+func (lsp *LanguageServer) cancelRequest(client *jsonrpc2.Conn, id jsonrpc2.ID) {
+	err := client.Notify(context.Background(), "$/cancelRequest", protocol.CancelParams{ID: id.Num})
 	if err != nil {
-		log.Warnf("LanguageServer Shutdown close rpc connection failed. err:%s", err)
+		log.Warnf("LanguageServer cancel request failed. id:%s, err: %s", id, err)
 	}
 }
 
-func (lsp *LanguageServer) InitWorkSpace(name, uri string) {
+// resolve asks the server for the lazily computed parts of item through a */resolve method.
+// It reports whether resolved was filled in, callers keep item when the server does not
+// resolve it or the request fails.
+func (lsp *LanguageServer) resolve(ctx context.Context, method string, item, resolved interface{}) (bool, error) {
+	if !lsp.Supports(method) {
+		return false, nil
+	}
+	err := lsp.call(ctx, method, item, resolved)
+	return err == nil, err
+}
+
+func (lsp *LanguageServer) InitWorkSpace(ctx context.Context, name, uri string) (*protocol.InitializeResult, error) {
 	log.Infof("LanguageServer InitWorkSpace start. name:%s, uri:%s", name, uri)
+	err := lsp.transition(StateInitializing, StateStarted)
+	if err != nil {
+		return nil, err
+	}
+	initializeResult, err := lsp.initialize(ctx, name, uri)
+	if err != nil {
+		_ = lsp.transition(StateStarted, StateInitializing)
+		return nil, err
+	}
+	err = lsp.transition(StateInitialized, StateInitializing)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("LanguageServer InitWorkSpace success")
+	return initializeResult, nil
+}
 
-	initializeParams := protocol.InitializeParams{}
-	initializeParams.ClientInfo.Name = "test"
-	initializeParams.ClientInfo.Version = "v1.0.2"
-	initializeParams.WorkspaceFolders = []protocol.WorkspaceFolder{{Name: name, URI: uri}}
+func (lsp *LanguageServer) initialize(ctx context.Context, name, uri string) (*protocol.InitializeResult, error) {
 
-	initializeResult := protocol.InitializeResult{}
-	err := lsp.rpcConn.Call(lsp.ctx, "initialize", initializeParams, &initializeResult, nil)
+	clientOptions := DefaultClientOptions()
+	if lsp.serverConfig.Client != nil {
+		clientOptions = *lsp.serverConfig.Client
+	}
+	initializeParams := clientOptions.initializeParams([]protocol.WorkspaceFolder{{Name: name, URI: uri}})
+
+	lsp.mutex.Lock()
+	lsp.workspaceFolders = initializeParams.WorkspaceFolders
+	lsp.mutex.Unlock()
+
+	var raw json.RawMessage
+	err := lsp.call(ctx, "initialize", initializeParams, &raw)
+	if err != nil {
+		return nil, err
+	}
+	initializeResult, capabilities, err := decodeInitializeResult(raw)
 	if err != nil {
-		log.Errorf("InitWorkSpace call json rpc method `initialize` failed. err: %s", err)
+		return nil, err
 	}
 
-	log.Infof("InitWorkSpace initialize response: %s", pretty.Sprint(initializeResult))
+	lsp.mutex.Lock()
+	lsp.capabilities = capabilities
+	lsp.mutex.Unlock()
+	log.Infof("LanguageServer InitWorkSpace server:%s, sync kind:%v", initializeResult.ServerInfo.Name, textDocumentSyncKind(capabilities.typed))
 
-	err = lsp.rpcConn.Call(lsp.ctx, "initialized", protocol.InitializedParams{}, nil, nil)
+	err = lsp.notify(ctx, "initialized", protocol.InitializedParams{})
 	if err != nil {
-		log.Errorf("InitWorkSpace call json rpc method `initialized` failed. err: %s", err)
+		return nil, err
 	}
-	log.Infof("LanguageServer InitWorkSpace success")
+	return initializeResult, nil
 }
 
-func (lsp *LanguageServer) DidOpenTextDocument(url, text, languageId string) {
+func (lsp *LanguageServer) DidOpenTextDocument(ctx context.Context, url, text, languageId string) error {
 	log.Infof("DidOpenTextDocument start")
+	lsp.documentMutex.Lock()
+	defer lsp.documentMutex.Unlock()
+	return lsp.didOpenLocked(ctx, url, text, languageId)
+}
+
+func (lsp *LanguageServer) didOpenLocked(ctx context.Context, url, text, languageId string) error {
+	document, err := lsp.documents.open(protocol.DocumentURI(url), languageId, text)
+	if err != nil {
+		return err
+	}
+
 	didOpenParam := protocol.DidOpenTextDocumentParams{}
-	didOpenParam.TextDocument.URI = protocol.DocumentURI(url)
-	didOpenParam.TextDocument.Version = 0
-	didOpenParam.TextDocument.LanguageID = languageId
-	didOpenParam.TextDocument.Text = text
-	err := lsp.rpcConn.Call(lsp.ctx, "textDocument/didOpen", didOpenParam, nil, nil)
+	didOpenParam.TextDocument.URI = document.URI
+	didOpenParam.TextDocument.Version = document.Version
+	didOpenParam.TextDocument.LanguageID = document.LanguageID
+	didOpenParam.TextDocument.Text = document.Text
+	err = lsp.notify(ctx, "textDocument/didOpen", didOpenParam)
 	if err != nil {
-		log.Errorf("DidOpenTextDocument call json rpc method [textDocument/didOpen] failed. err: %s", err)
+		_ = lsp.documents.close(document.URI)
+		return err
 	}
+	return nil
 }
 
-func (lsp *LanguageServer) DidSaveTextDocument(url, data string) {
-	log.Infof("DidSaveTextDocument start")
-	didSaveParam := protocol.DidSaveTextDocumentParams{}
-	didSaveParam.TextDocument.URI = protocol.DocumentURI(url)
-	didSaveParam.Text = &data
-	err := lsp.rpcConn.Call(lsp.ctx, "textDocument/didSave", didSaveParam, nil, nil)
+// DidChangeTextDocument applies the changes to the local copy of the document and
+// forwards them in the sync mode the server asked for during initialize.
+func (lsp *LanguageServer) DidChangeTextDocument(ctx context.Context, url string, changes []protocol.TextDocumentContentChangeEvent) error {
+	lsp.documentMutex.Lock()
+	defer lsp.documentMutex.Unlock()
+	return lsp.didChangeLocked(ctx, url, changes)
+}
+
+// DidChangeVersionedTextDocument is DidChangeTextDocument for changes an editor made to the
+// version before version. Changes made to another version were made to text the document no
+// longer has and are refused.
+func (lsp *LanguageServer) DidChangeVersionedTextDocument(ctx context.Context, url string, version int32, changes []protocol.TextDocumentContentChangeEvent) error {
+	lsp.documentMutex.Lock()
+	defer lsp.documentMutex.Unlock()
+	document, ok := lsp.documents.get(protocol.DocumentURI(url))
+	if ok && document.Version != version-1 {
+		return fmt.Errorf("document %s is at version %d, the changes are for version %d", url, document.Version, version-1)
+	}
+	return lsp.didChangeLocked(ctx, url, changes)
+}
+
+func (lsp *LanguageServer) didChangeLocked(ctx context.Context, url string, changes []protocol.TextDocumentContentChangeEvent) error {
+	document, err := lsp.documents.change(protocol.DocumentURI(url), changes)
 	if err != nil {
-		log.Errorf("DidSaveTextDocument call json rpc method [textDocument/didSave] failed. err: %s", err)
+		return err
+	}
+
+	switch lsp.documentSyncKind() {
+	case protocol.Incremental:
+	case protocol.Full:
+		changes = []protocol.TextDocumentContentChangeEvent{{Text: document.Text}}
+	default:
+		return nil
 	}
 
-	log.Infof("DidSaveTextDocument success.")
+	didChangeParam := protocol.DidChangeTextDocumentParams{}
+	didChangeParam.TextDocument.URI = document.URI
+	didChangeParam.TextDocument.Version = document.Version
+	didChangeParam.ContentChanges = changes
+	return lsp.notify(ctx, "textDocument/didChange", didChangeParam)
 }
 
-func (lsp *LanguageServer) ExecuteGoModTidy(uri string) {
-	log.Infof("ExecuteGoModTidy start")
-	type uris struct {
-		URIs []string `json:"URIs"`
+// DidSaveTextDocument writes the text to the file of the document and then tells the server,
+// so that builds and go.mod commands reading the disk see it. Only files inside a workspace
+// folder are written.
+func (lsp *LanguageServer) DidSaveTextDocument(ctx context.Context, url, data string) error {
+	log.Infof("DidSaveTextDocument start")
+	path, err := lsp.workspacePath(protocol.DocumentURI(url))
+	if err != nil {
+		return err
 	}
-	u := uris{
-		URIs: []string{uri},
+	err = writeFile(path, data)
+	if err != nil {
+		return fmt.Errorf("save %s failed: %w", url, err)
 	}
+	didSaveParam := protocol.DidSaveTextDocumentParams{}
+	didSaveParam.TextDocument.URI = protocol.DocumentURI(url)
+	didSaveParam.Text = &data
+	return lsp.notify(ctx, "textDocument/didSave", didSaveParam)
+}
+
+func (lsp *LanguageServer) DidCloseTextDocument(ctx context.Context, url string) error {
+	log.Infof("DidCloseTextDocument start")
+	lsp.documentMutex.Lock()
+	defer lsp.documentMutex.Unlock()
+	return lsp.didCloseLocked(ctx, url)
+}
 
-	marshal, err := json.Marshal(u)
+func (lsp *LanguageServer) didCloseLocked(ctx context.Context, url string) error {
+	err := lsp.documents.close(protocol.DocumentURI(url))
 	if err != nil {
-		log.Errorf("[ExecuteGoModTidy] marshal data faild. err: %s", err)
+		return err
 	}
+	lsp.semanticTokens.forget(protocol.DocumentURI(url))
+	lsp.codeLenses.forget(protocol.DocumentURI(url))
 
-	executeParams := protocol.ExecuteCommandParams{}
-	executeParams.Command = "gopls.tidy"
-	executeParams.Arguments = []json.RawMessage{marshal}
-	executeParams.WorkDoneToken = "11111111111111111"
-	response := make(map[string]interface{})
-	err = lsp.rpcConn.Call(lsp.ctx, "workspace/executeCommand", &executeParams, &response, nil)
+	didCloseParam := protocol.DidCloseTextDocumentParams{}
+	didCloseParam.TextDocument.URI = protocol.DocumentURI(url)
+	return lsp.notify(ctx, "textDocument/didClose", didCloseParam)
+}
+
+// workspacePath is the file of uri if it lies inside one of the workspace folders.
+func (lsp *LanguageServer) workspacePath(uri protocol.DocumentURI) (string, error) {
+	path, err := uriToPath(uri)
 	if err != nil {
-		log.Errorf("call json rpc method [workspace/executeCommand gopls.tidy] failed. err: %s", err)
+		return "", err
+	}
+	path = filepath.Clean(path)
+	for _, folder := range lsp.WorkspaceFolders() {
+		root, err := uriToPath(protocol.DocumentURI(folder.URI))
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return path, nil
+		}
 	}
+	return "", fmt.Errorf("%s is outside the workspace", uri)
+}
 
-	log.Infof("ExecuteGoModTidy success: %s", pretty.Sprint(response))
+func (lsp *LanguageServer) WorkspaceFolders() []protocol.WorkspaceFolder {
+	lsp.mutex.Lock()
+	defer lsp.mutex.Unlock()
+	folders := make([]protocol.WorkspaceFolder, len(lsp.workspaceFolders))
+	copy(folders, lsp.workspaceFolders)
+	return folders
 }
 
-func (lsp *LanguageServer) ExecuteGoModGenerate(uri string) {
-	log.Infof("ExecuteGoModGenerate start")
-	type uriS struct {
-		URI string `json:"URI"`
+// configuration looks up a dotted section such as "gopls" or "gopls.analyses" in the settings.
+func (lsp *LanguageServer) configuration(section string) interface{} {
+	settings := lsp.settingsSnapshot()
+	if section == "" {
+		return settings
+	}
+	if value, ok := settings[section]; ok {
+		return value
 	}
-	u := uriS{
-		URI: uri,
+	var current interface{} = settings
+	for _, key := range strings.Split(section, ".") {
+		values, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = values[key]
 	}
+	return current
+}
 
-	marshal, err := json.Marshal(u)
-	if err != nil {
-		log.Errorf("[ExecuteGoModGenerate] marshal data faild. err: %s", err)
+func (lsp *LanguageServer) settingsSnapshot() map[string]interface{} {
+	lsp.mutex.Lock()
+	defer lsp.mutex.Unlock()
+	return lsp.settings
+}
+
+// UpdateSettings replaces the settings served to workspace/configuration and tells the server
+// to fetch them again. They are re-applied after a reconnect as well.
+func (lsp *LanguageServer) UpdateSettings(ctx context.Context, settings map[string]interface{}) error {
+	lsp.mutex.Lock()
+	lsp.settings = settings
+	lsp.mutex.Unlock()
+	return lsp.notify(ctx, "workspace/didChangeConfiguration", protocol.DidChangeConfigurationParams{Settings: settings})
+}
+
+func (lsp *LanguageServer) register(registrations []protocol.Registration) {
+	lsp.mutex.Lock()
+	defer lsp.mutex.Unlock()
+	for _, registration := range registrations {
+		log.Infof("LanguageServer register capability. id:%s, method:%s", registration.ID, registration.Method)
+		lsp.registrations[registration.ID] = registration
+	}
+}
+
+func (lsp *LanguageServer) unregister(unregistrations []protocol.Unregistration) {
+	lsp.mutex.Lock()
+	defer lsp.mutex.Unlock()
+	for _, unregistration := range unregistrations {
+		log.Infof("LanguageServer unregister capability. id:%s, method:%s", unregistration.ID, unregistration.Method)
+		delete(lsp.registrations, unregistration.ID)
 	}
+}
 
-	executeParams := protocol.ExecuteCommandParams{}
-	executeParams.Command = "gopls.generate_gopls_mod"
-	executeParams.Arguments = []json.RawMessage{marshal}
-	executeParams.WorkDoneToken = "11111111111111111"
-	response := make(map[string]interface{})
-	err = lsp.rpcConn.Call(lsp.ctx, "workspace/executeCommand", &executeParams, &response, nil)
-	if err != nil {
-		log.Errorf("call json rpc method [workspace/executeCommand gopls.generate_gopls_mod] failed. err: %s", err)
+func (lsp *LanguageServer) Document(url string) (TextDocument, bool) {
+	return lsp.documents.get(protocol.DocumentURI(url))
+}
+
+func (lsp *LanguageServer) Diagnostics(url string) (DiagnosticsEvent, bool) {
+	return lsp.diagnostics.get(protocol.DocumentURI(url))
+}
+
+func (lsp *LanguageServer) AllDiagnostics() []DiagnosticsEvent {
+	return lsp.diagnostics.all()
+}
+
+// SubscribeDiagnostics streams every accepted publishDiagnostics until the returned cancel func is called.
+func (lsp *LanguageServer) SubscribeDiagnostics() (<-chan DiagnosticsEvent, func()) {
+	return lsp.diagnostics.subscribe()
+}
+
+func (lsp *LanguageServer) CancelRequest(ctx context.Context, id jsonrpc2.ID) error {
+	params := protocol.CancelParams{ID: id.Num}
+	if id.IsString {
+		params.ID = id.Str
 	}
+	return lsp.notify(ctx, "$/cancelRequest", params)
+}
 
-	log.Infof("ExecuteGoModGenerate success: %s", pretty.Sprint(response))
+func (lsp *LanguageServer) SetTrace(ctx context.Context, value protocol.TraceValues) error {
+	return lsp.notify(ctx, "$/setTrace", protocol.SetTraceParams{Value: value})
 }
 
-func (lsp *LanguageServer) Completion(uri string, line uint32, character uint32) {
-	////自动补全
+func (lsp *LanguageServer) ExecuteCommand(ctx context.Context, command string, arguments ...interface{}) (interface{}, error) {
+	log.Infof("ExecuteCommand start. command:%s", command)
+	executeParams := protocol.ExecuteCommandParams{}
+	executeParams.Command = command
+	for _, argument := range arguments {
+		marshal, err := json.Marshal(argument)
+		if err != nil {
+			return nil, fmt.Errorf("lsp workspace/executeCommand %s: marshal argument failed: %w", command, err)
+		}
+		executeParams.Arguments = append(executeParams.Arguments, marshal)
+	}
 
-	completionParams := protocol.CompletionParams{}
-	completionParams.WorkDoneToken = "333333333333333333333"
-	completionParams.TextDocument.URI = protocol.DocumentURI(uri)
-	completionParams.Position.Line = line
-	completionParams.Position.Character = character
-	log.Infof("textDocument/completion request: %s", pretty.Sprint(completionParams))
-	completionList := protocol.CompletionList{}
-	err := lsp.rpcConn.Call(context.Background(), "textDocument/completion", &completionParams, &completionList, nil)
+	var response interface{}
+	err := lsp.call(ctx, "workspace/executeCommand", &executeParams, &response)
 	if err != nil {
-		log.Errorf("call json rpc method failed. err: %s", err)
+		return nil, err
 	}
-	log.Infof("textDocument/completion: %s", pretty.Sprint(completionList))
+	return response, nil
+}
 
+func (lsp *LanguageServer) ExecuteGoModTidy(ctx context.Context, uri string) error {
+	type uris struct {
+		URIs []string `json:"URIs"`
+	}
+	_, err := lsp.ExecuteCommand(ctx, "gopls.tidy", uris{URIs: []string{uri}})
+	return err
 }
 
-func (lsp *LanguageServer) fatalfIfNotInit() {
-	if !lsp.initialized {
-		log.Fatalf("start language server failed. please init first")
+func (lsp *LanguageServer) ExecuteGoModGenerate(ctx context.Context, uri string) error {
+	type uriS struct {
+		URI string `json:"URI"`
 	}
+	_, err := lsp.ExecuteCommand(ctx, "gopls.generate_gopls_mod", uriS{URI: uri})
+	return err
 }
 
 func main() {
+	addr := flag.String("addr", ":8080", "address the IDE is served on")
+	staticDir := flag.String("static", "../codemirror", "directory of the CodeMirror IDE")
+	workspaceRoot := flag.String("workspaces", os.TempDir(), "directory the workspaces of the sessions are created in")
+	maxSessions := flag.Int("max-sessions", 8, "maximum number of sessions, 0 for no limit")
+	idleTimeout := flag.Duration("idle-timeout", 10*time.Minute, "shut down sessions idle for this long, 0 never does")
+	demo := flag.Bool("demo", false, "run the language server demo instead of serving the IDE")
+	flag.Parse()
+	if *demo {
+		runDemo()
+		return
+	}
+
 	ctx := context.Background()
-	languageServer := InitLanguageServer(ctx, ServerConfig{NetWork: "tcp", Address: "192.168.88.201:9877"})
-	languageServer.Start()
-	languageServer.InitWorkSpace(workSpaceName, workSpaceURI)
+	settings := map[string]interface{}{"gopls": map[string]interface{}{"usePlaceholders": true, "semanticTokens": true}}
+	sessions := newSessionManager(ctx, SessionConfig{
+		WorkspaceRoot: *workspaceRoot,
+		MaxSessions:   *maxSessions,
+		IdleTimeout:   *idleTimeout,
+		Server:        ServerConfig{Transport: TransportStdio, Command: "gopls", Args: []string{"serve"}, Settings: settings},
+		Files:         map[string]string{"hello.go": codeTemplate, "go.mod": modTemplate, "go.sum": sumTemplate},
+		Documents:     []string{"hello.go", "go.mod"},
+		LanguageIDs:   map[string]string{"go.mod": "go.mod"},
+	})
+	httpServer := &http.Server{Addr: *addr, Handler: newWebServer(ctx, sessions, *staticDir).handler()}
+
+	signalCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-signalCtx.Done()
+		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		err := httpServer.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Errorf("http server shutdown failed. err: %s", err)
+		}
+	}()
+
+	log.Infof("IDE listening on %s", *addr)
+	err := httpServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Errorf("http server failed. err: %s", err)
+	}
+	sessions.close()
+}
+
+// runDemo walks through the language server features against the template workspace.
+func runDemo() {
+	ctx := context.Background()
+	settings := map[string]interface{}{"gopls": map[string]interface{}{"usePlaceholders": true, "semanticTokens": true}}
+	languageServer := InitLanguageServer(ctx, ServerConfig{Transport: TransportStdio, Command: "gopls", Args: []string{"serve"}, Settings: settings})
+	//languageServer := InitLanguageServer(ctx, ServerConfig{Transport: TransportTCP, NetWork: "tcp", Address: "192.168.88.201:9877"})
+	err := languageServer.Start()
+	if err != nil {
+		log.Fatalf("LanguageServer start failed. err: %s", err)
+	}
+	initializeResult, err := languageServer.InitWorkSpace(ctx, workSpaceName, workSpaceURI)
+	if err != nil {
+		log.Fatalf("InitWorkSpace failed. err: %s", err)
+	}
+	log.Infof("InitWorkSpace initialize response: %s", pretty.Sprint(initializeResult))
+	for _, method := range []string{"textDocument/rename", "textDocument/prepareRename", "textDocument/semanticTokens/full/delta", "textDocument/moniker"} {
+		log.Infof("language server supports %s: %t", method, languageServer.Supports(method))
+	}
 
 	//languageServer.DidOpenTextDocument(workSpaceURI+"/hello/", "")
 
 	helloURI := workSpaceURI + "hello.go"
 	codeData := readData(codeTemplate)
-	languageServer.DidOpenTextDocument(helloURI, codeData, "go")
-	languageServer.DidSaveTextDocument(helloURI, codeData)
+	logIfError(languageServer.DidOpenTextDocument(ctx, helloURI, codeData, "go"))
+	logIfError(languageServer.DidSaveTextDocument(ctx, helloURI, codeData))
+	logIfError(languageServer.DidChangeTextDocument(ctx, helloURI, []protocol.TextDocumentContentChangeEvent{{
+		Range: &protocol.Range{Start: protocol.Position{Line: 16}, End: protocol.Position{Line: 16}},
+		Text:  "\nfunc (p *Person) Name() string {\n\treturn p.name\n}\n",
+	}}))
 
 	modURI := workSpaceURI + "go.mod"
 	modData := readData(modTemplate)
-	languageServer.DidOpenTextDocument(modURI, modData, "go.mod")
-	languageServer.DidSaveTextDocument(modURI, modData)
+	logIfError(languageServer.DidOpenTextDocument(ctx, modURI, modData, "go.mod"))
+	logIfError(languageServer.DidSaveTextDocument(ctx, modURI, modData))
 
 	//sumURI := workSpaceURI + "go.sum"
 	//sumData := readData(sumTemplate)
 	//languageServer.DidOpenTextDocument(sumURI, sumData, "go.sum")
 	//languageServer.DidSaveTextDocument(sumURI, sumData)
 
-	languageServer.ExecuteGoModTidy(modURI)
+	logIfError(languageServer.ExecuteGoModTidy(ctx, modURI))
 
 	//fmt.
-	languageServer.Completion(helloURI, 7, 5)
+	printCompletion(languageServer.Completion(ctx, helloURI, 7, 5, protocol.CompletionContext{}))
 	//io_tool.
-	languageServer.Completion(helloURI, 8, 20)
+	printCompletion(languageServer.Completion(ctx, helloURI, 8, 20, protocol.CompletionContext{}))
 	//Per
-	languageServer.Completion(helloURI, 9, 21)
-
-	languageServer.Completion(helloURI, 10, 20)
-
-	languageServer.Shutdown()
-
-	////在工作空间执行命令
-	//executeParams1 := ExecuteCommandParams{}
-	//executeParams1.Command = "gopls.tidy"
-	//executeParams1.Arguments = []json.RawMessage{[]byte(`{"URI":"file://test"}`)}
-	//executeParams1.WorkDoneToken = "11111111111111111"
-	//response = make(map[string]interface{})
-	////executeParams.Arguments = []json.RawMessage{[]byte("[version]")}
-	//err = client.Call(ctx, "workspace/executeCommand", &executeParams1, &response, nil)
-	//if err != nil {
-	//	log.Errorf("gopls.tidy call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("workspace/executeCommand gopls.tidy: %s", pretty.Sprint(response))
-	//
-	////在工作空间执行命令
-	//executeParams2 := ExecuteCommandParams{}
-	//executeParams2.Command = "gopls.vendor"
-	//executeParams2.Arguments = []json.RawMessage{[]byte(`{"URI":"file://test/"}`)}
-	//executeParams2.WorkDoneToken = "xxxxxxxxxxxxxxxx"
-	//response = make(map[string]interface{})
-	////executeParams.Arguments = []json.RawMessage{[]byte("[version]")}
-	//err = client.Call(ctx, "workspace/executeCommand", &executeParams2, &response, nil)
-	//if err != nil {
-	//	log.Errorf("gopls.vendor call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("workspace/executeCommand gopls.vendor: %s", pretty.Sprint(response))
-	//
-	//filesParams := DidChangeWatchedFilesParams{}
-	//filesParams.Changes = []FileEvent{{URI: "file://test//go.mod", Type: 6}, {URI: "file://test//hello.go", Type: 6}}
-	//response = make(map[string]interface{})
-	//err = client.Call(ctx, "workspace/didChangeWatchedFiles", filesParams, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("workspace/didChangeWatchedFiles response: %s", pretty.Sprint(response))
-	//
-	//didOpenParam := DidOpenTextDocumentParams{}
-	//didOpenParam.TextDocument.URI = "file://test//hello.go"
-	//didOpenParam.TextDocument.Version = 0
-	//didOpenParam.TextDocument.LanguageID = "go"
-	//didOpenParam.TextDocument.Text = "package main\n\nimport \"fmt\"\nimport \"github.com/sourcegraph/jsonrpc2\"\n\nfunc main() {\n\tresult := jsonrpc2.NewBufferedStream(nil, nil)\n\tfmt.Println(result)\n\tfmt.Println()\n}\n"
-	//response = make(map[string]interface{})
-	//err = client.Call(ctx, "textDocument/didOpen", didOpenParam, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/didOpen response: %s", pretty.Sprint(response))
-	//
-	//didSaveTextDocumentParams := DidSaveTextDocumentParams{}
-	//didSaveTextDocumentParams.TextDocument.URI = "file://test//hello.go"
-	//data := "package main\n\nimport \"fmt\"\nimport \"github.com/sourcegraph/jsonrpc2\"\n\nfunc main() {\n\tresult := jsonrpc2.NewBufferedStream(nil, nil)\n\tfmt.Println(result)\n\tfmt.Println()\n}\n"
-	//didSaveTextDocumentParams.Text = &data
-	//response = make(map[string]interface{})
-	//err = client.Call(ctx, "textDocument/didSave", &didSaveTextDocumentParams, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/didSave: %s", pretty.Sprint(response))
-	//
-	//didOpenParam = DidOpenTextDocumentParams{}
-	//didOpenParam.TextDocument.URI = "file://test//go.mod"
-	//didOpenParam.TextDocument.Version = 0
-	//didOpenParam.TextDocument.LanguageID = "go"
-	//didOpenParam.TextDocument.Text = "module hello\n\ngo 1.16\n"
-	//response = make(map[string]interface{})
-	//err = client.Call(ctx, "textDocument/didOpen", didOpenParam, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/didOpen response: %s", pretty.Sprint(response))
-	//
-	//didSaveTextDocumentParams = DidSaveTextDocumentParams{}
-	//didSaveTextDocumentParams.TextDocument.URI = "file://test//go.mod"
-	//data = "module hello\n\ngo 1.16\n"
-	//didSaveTextDocumentParams.Text = &data
-	//response = make(map[string]interface{})
-	//err = client.Call(ctx, "textDocument/didSave", &didSaveTextDocumentParams, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/didSave: %s", pretty.Sprint(response))
-	//
-	//completionParams := CompletionParams{}
-	//completionParams.WorkDoneToken = "333333333333333333333"
-	//completionParams.TextDocument.URI = "file://test//hello.go"
-	//completionParams.Position.Line = 1
-	//completionParams.Position.Character = 4
-	//
-	////自动补全
-	//log.Infof("textDocument/completion request: %s", pretty.Sprint(completionParams))
-	//completionList := CompletionList{}
-	//err = client.Call(ctx, "textDocument/completion", &completionParams, &completionList, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/completion: %s", pretty.Sprint(response))
-	//
-	////悬停信息
-	//hoverParams := HoverParams{}
-	//hoverParams.TextDocument.URI = "file://test//hello.go"
-	//hoverParams.Position.Line = 8
-	//hoverParams.Position.Character = 10
-	//hoverParams.WorkDoneToken = "44444444444444"
-	//response = make(map[string]interface{})
-	//log.Infof("textDocument/hover request: %s", pretty.Sprint(hoverParams))
-	//err = client.Call(ctx, "textDocument/hover", &hoverParams, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/hover: %s", pretty.Sprint(response))
-	//
-	////签名信息
-	//signatureHelpParams := SignatureHelpParams{}
-	//signatureHelpParams.TextDocument.URI = "file://test//hello.go"
-	//signatureHelpParams.Position.Line = 9
-	//signatureHelpParams.Position.Character = 17
-	//signatureHelpParams.WorkDoneToken = "5555555555555"
-	//response = make(map[string]interface{})
-	//log.Infof("textDocument/signatureHelp request: %s", pretty.Sprint(signatureHelpParams))
-	//err = client.Call(ctx, "textDocument/signatureHelp", &signatureHelpParams, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/signatureHelp: %s", pretty.Sprint(response))
-	//
-	//////声明位置
-	////definitionParams := DefinitionParams{}
-	////definitionParams.TextDocument.URI = "file://test//hello.go"
-	////definitionParams.Position.Line = 9
-	////definitionParams.Position.Character = 12
-	////definitionParams.WorkDoneToken = "6666666666666666666"
-	////response = make(map[string]interface{})
-	////log.Infof("textDocument/definition request: %s", pretty.Sprint(definitionParams))
-	////err = client.Call(ctx, "textDocument/definition", &definitionParams, &response, nil)
-	////if err != nil {
-	////	log.Errorf("call json rpc method failed. err: %s", err)
-	////}
-	////log.Infof("textDocument/definition: %s", pretty.Sprint(response))
-	//
-	////查找引用
-	//referenceParams := ReferenceParams{}
-	//referenceParams.TextDocument.URI = "file://test//hello.go"
-	//referenceParams.Position.Line = 7
-	//referenceParams.Position.Character = 5
-	//referenceParams.WorkDoneToken = "77777777777777777777"
-	//responseLocation := make([]Location, 0)
-	//log.Info("textDocument/references request: %s", pretty.Sprint(referenceParams))
-	//err = client.Call(ctx, "textDocument/references", &referenceParams, &responseLocation, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/references: %s", pretty.Sprint(responseLocation))
-	//
-	////代码高亮
-	//documentHighlightParams := DocumentHighlightParams{}
-	//documentHighlightParams.TextDocument.URI = "file://test//hello.go"
-	//documentHighlightParams.Position.Line = 7
-	//documentHighlightParams.Position.Character = 5
-	//documentHighlightParams.WorkDoneToken = "888888888888888"
-	//documentHighlightResponse := make([]DocumentHighlight, 0)
-	//log.Info("textDocument/documentHighlight request: %s", pretty.Sprint(documentHighlightParams))
-	//err = client.Call(ctx, "textDocument/documentHighlight", &referenceParams, &documentHighlightResponse, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/documentHighlight: %s", pretty.Sprint(documentHighlightResponse))
-	//
-	////文档符号
-	//documentSymbolParams := DocumentSymbolParams{}
-	//documentSymbolParams.TextDocument.URI = "file://test//hello.go"
-	//documentSymbolParams.WorkDoneToken = "99999999999999999999"
-	//documentSymbolResponse := make([]DocumentSymbol, 0)
-	//log.Info("textDocument/documentSymbol request: %s", pretty.Sprint(documentSymbolParams))
-	//err = client.Call(ctx, "textDocument/documentSymbol", &documentSymbolParams, &documentSymbolResponse, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/documentSymbol: %s", pretty.Sprint(documentSymbolResponse))
-	//
-	////可以执行的优化命令
-	//codeActionParams := CodeActionParams{}
-	//codeActionParams.TextDocument.URI = "file://test//hello.go"
-	//codeActionParams.WorkDoneToken = "1010101010101010"
-	//codeActionParams.Range.Start.Line = 7
-	//codeActionParams.Range.Start.Character = 0
-	//codeActionParams.Range.End.Line = 8
-	//codeActionParams.Range.End.Line = 0
-	//codeActionResponse := make([]CodeAction, 0)
-	//log.Info("textDocument/codeAction request: %s", pretty.Sprint(codeActionParams))
-	//err = client.Call(ctx, "textDocument/codeAction", &codeActionParams, &codeActionResponse, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/codeAction: %s", pretty.Sprint(codeActionResponse))
-	//
-	////代码信息显示
-	//codeLensParams := CodeLensParams{}
-	//codeLensParams.TextDocument.URI = "file://test//hello.go"
-	//codeLensParams.WorkDoneToken = "1212121212121212"
-	//codeLensResponse := CodeLens{}
-	//log.Info("textDocument/codeLens: %s", pretty.Sprint(codeLensParams))
-	//err = client.Call(ctx, "textDocument/codeLens", &codeLensParams, &codeLensResponse, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/codeLens: %s", pretty.Sprint(codeLensResponse))
-	//
-	////文档颜色
-	//documentColorParams := DocumentColorParams{}
-	//documentColorParams.TextDocument.URI = "file://test//hello.go"
-	//documentColorParams.WorkDoneToken = "1313131313131313131313"
-	//colorInformation := ColorInformation{}
-	//log.Info("textDocument/documentColor: %s", pretty.Sprint(documentColorParams))
-	//err = client.Call(ctx, "textDocument/documentColor", &documentColorParams, &colorInformation, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/documentColor: %s", pretty.Sprint(colorInformation))
-	//
-	////重命名
-	//renameParams := RenameParams{}
-	//renameParams.TextDocument.URI = "file://test//hello.go"
-	//renameParams.Position.Line = 6
-	//renameParams.Position.Character = 3
-	//renameParams.NewName = "aa"
-	//renameParams.WorkDoneToken = "1414141414141441411441"
-	//renameResponse := WorkspaceEdit{}
-	//log.Info("textDocument/rename: %s", pretty.Sprint(renameParams))
-	//err = client.Call(ctx, "textDocument/rename", &renameParams, &renameResponse, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/rename: %s", pretty.Sprint(renameResponse))
-	//
-	////在工作空间执行命令
-	//executeParams := ExecuteCommandParams{}
-	//executeParams.Command = "gopls.list_known_packages"
-	//executeParams.WorkDoneToken = "2222222222222222"
-	//executeParams.Arguments = []json.RawMessage{[]byte(`{"URI":"file://test/hello1.go"}`)}
-	//response = make(map[string]interface{})
-	////executeParams.Arguments = []json.RawMessage{[]byte("[version]")}
-	//err = client.Call(ctx, "workspace/executeCommand", &executeParams, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("workspace/executeCommand: %s", pretty.Sprint(response))
-	//
-	////在工作空间查询符号
-	//params := WorkspaceSymbolParams{}
-	//params.Query = "math"
-	//symbolResponse := make([]interface{}, 0)
-	//err = client.Call(ctx, "workspace/symbol", &params, &symbolResponse, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("workspace/symbol: %s", pretty.Sprint(symbolResponse))
-	//
-	////todo not implement
-	////createFilesParams := CreateFilesParams{}
-	////createFilesParams.Files = []FileCreate{{URI: "file://hello1.go"}}
-	////err = client.Call(ctx, "workspace/didCreateFiles", &createFilesParams, &response, nil)
-	////if err != nil {
-	////	log.Errorf("call json rpc method failed. err: %s", err)
-	////}
-	////log.Infof("workspace/didCreateFiles: %s", pretty.Sprint(response))
-	//
-	//didOpenParam = DidOpenTextDocumentParams{}
-	//didOpenParam.TextDocument.URI = "file://test//hello1.go"
-	//didOpenParam.TextDocument.Version = 0
-	//didOpenParam.TextDocument.LanguageID = "GO"
-	//didOpenParam.TextDocument.Text = "package main\n\nimport \"fmt\"\n\nfunc main(){\n    fmt.Println(\"Hello\")\n}"
-	//response = make(map[string]interface{})
-	//err = client.Call(ctx, "textDocument/didOpen", didOpenParam, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/didOpen response: %s", pretty.Sprint(response))
-	//
-	//didChangeTextDocumentParams := DidChangeTextDocumentParams{}
-	//didChangeTextDocumentParams.TextDocument.URI = "file://test//hello1.go"
-	//didChangeTextDocumentParams.TextDocument.Version = 0
-	//didChangeTextDocumentParams.ContentChanges = make([]TextDocumentContentChangeEvent, 1)
-	//didChangeTextDocumentParams.ContentChanges[0].Text = "package main\n\nimport \"fmt\"\n\nfunc main(){\n    fmt.Println(\"Hello\")\n}"
-	//response = make(map[string]interface{})
-	//err = client.Call(ctx, "textDocument/didChange", didChangeTextDocumentParams, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/didChange response: %s", pretty.Sprint(response))
-	//
-	//didSaveTextDocumentParams = DidSaveTextDocumentParams{}
-	//didSaveTextDocumentParams.TextDocument.URI = "file://test//hello1.go"
-	//data = "package main\n\nimport \"fmt\"\n\nfunc main(){\n    fmt.Println(\"Hello\")\n}"
-	//didSaveTextDocumentParams.Text = &data
-	//response = make(map[string]interface{})
-	//err = client.Call(ctx, "textDocument/didSave", didSaveTextDocumentParams, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/didSave: %s", pretty.Sprint(response))
-	//
-	//didCloseTextDocumentParams := DidCloseTextDocumentParams{}
-	//didCloseTextDocumentParams.TextDocument.URI = "file://test//hello1.go"
-	//response = make(map[string]interface{})
-	//err = client.Call(ctx, "textDocument/didClose", didCloseTextDocumentParams, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("textDocument/didClose: %s", pretty.Sprint(response))
-	//
-	//response = make(map[string]interface{})
-	//err = client.Call(ctx, "shutdown", nil, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//log.Infof("shutdown")
-	//
-	//response = make(map[string]interface{})
-	//err = client.Call(ctx, "exit", nil, &response, nil)
-	//if err != nil {
-	//	log.Errorf("call json rpc method failed. err: %s", err)
-	//}
-	//client.Close()
-	//log.Infof("exit")
-	//
-	////referenceParams := ReferenceParams{}
-	////referenceParams.TextDocument.URI = "file://test//test.go"
-	////referenceParams.Position.Line = 6
-	////referenceParams.Position.Character = 6
-	////referenceParams.Context = ReferenceContext{IncludeDeclaration: true}
-	////response = make(map[string]interface{})
-	////err = client.Call(ctx, "textDocument/references", referenceParams, &response, nil)
-	////if err != nil {
-	////	log.Errorf("call json rpc method failed. err: %s", err)
-	////}
-	////log.Infof("initialized: %s", pretty.Sprint(response))
+	printCompletion(languageServer.Completion(ctx, helloURI, 9, 21, protocol.CompletionContext{}))
+
+	printCompletion(languageServer.Completion(ctx, helloURI, 10, 20, protocol.CompletionContext{TriggerKind: protocol.Invoked}))
+
+	//fmt.Println
+	hover, err := languageServer.Hover(ctx, helloURI, 7, 6)
+	logIfError(err)
+	log.Infof("hover: %s", pretty.Sprint(hover))
+	//fmt.Println(
+	signatureHelp, err := languageServer.SignatureHelp(ctx, helloURI, 8, 13)
+	logIfError(err)
+	log.Infof("signatureHelp: %s", pretty.Sprint(signatureHelp))
+	//new(Person)
+	definition, err := languageServer.Definition(ctx, helloURI, 9, 18)
+	logIfError(err)
+	log.Infof("definition: %s", pretty.Sprint(definition))
+	references, err := languageServer.References(ctx, helloURI, 13, 6, true)
+	logIfError(err)
+	log.Infof("references: %s", pretty.Sprint(references))
+	prepareRename, err := languageServer.PrepareRename(ctx, helloURI, 13, 6)
+	logIfError(err)
+	log.Infof("prepareRename: %s", pretty.Sprint(prepareRename))
+	renameEdit, err := languageServer.Rename(ctx, helloURI, 13, 6, "Human")
+	logIfError(err)
+	if renameEdit != nil {
+		logIfError(languageServer.ApplyWorkspaceEdit(ctx, *renameEdit))
+	}
+	if document, ok := languageServer.Document(helloURI); ok {
+		log.Infof("after rename: version:%d\n%s", document.Version, document.Text)
+	}
+
+	for _, diagnostics := range languageServer.AllDiagnostics() {
+		log.Infof("diagnostics: %s", pretty.Sprint(diagnostics))
+	}
+
+	codeActions, err := languageServer.CodeActions(ctx, helloURI, protocol.Range{End: protocol.Position{Line: 20}}, nil)
+	logIfError(err)
+	for _, codeAction := range codeActions {
+		log.Infof("codeAction: %s, kind:%s", codeAction.Title, codeAction.Kind)
+		if codeAction.Kind == protocol.SourceOrganizeImports {
+			logIfError(languageServer.ExecuteCodeAction(ctx, codeAction))
+		}
+	}
+
+	semanticTokens, err := languageServer.SemanticTokens(ctx, helloURI)
+	logIfError(err)
+	if len(semanticTokens) > 3 {
+		log.Infof("semantic tokens: %d, first: %s", len(semanticTokens), pretty.Sprint(semanticTokens[:3]))
+	}
+	semanticTokens, err = languageServer.SemanticTokens(ctx, helloURI)
+	logIfError(err)
+	log.Infof("semantic tokens again: %d", len(semanticTokens))
+
+	documentSymbols, err := languageServer.DocumentSymbols(ctx, helloURI)
+	logIfError(err)
+	log.Infof("document symbols: %s", pretty.Sprint(documentSymbols))
+	workspaceSymbols, err := languageServer.WorkspaceSymbols(ctx, "hum", 0, 5)
+	logIfError(err)
+	log.Infof("workspace symbols: %s", pretty.Sprint(workspaceSymbols))
+
+	for _, symbol := range documentSymbols {
+		if symbol.Name != "main" {
+			continue
+		}
+		start := symbol.SelectionRange.Start
+		callTree, err := languageServer.CallTree(ctx, helloURI, start.Line, start.Character, CallsOutgoing, 2)
+		logIfError(err)
+		callTreeJSON, err := json.Marshal(callTree)
+		logIfError(err)
+		log.Infof("call tree: %s", callTreeJSON)
+	}
+
+	foldingRanges, err := languageServer.FoldingRanges(ctx, helloURI)
+	logIfError(err)
+	foldingRangesJSON, err := json.Marshal(foldingRanges)
+	logIfError(err)
+	log.Infof("folding ranges: %s", foldingRangesJSON)
 
+	for _, symbol := range documentSymbols {
+		if symbol.Name != "main" {
+			continue
+		}
+		selectionRanges, err := languageServer.SelectionRanges(ctx, helloURI, symbol.SelectionRange.Start)
+		logIfError(err)
+		for _, selectionRange := range selectionRanges {
+			log.Infof("selection ranges: %s", pretty.Sprint(selectionRangeChain(selectionRange)))
+		}
+		start := symbol.SelectionRange.Start
+		linkedEditingRanges, err := languageServer.LinkedEditingRanges(ctx, helloURI, start.Line, start.Character)
+		logIfError(err)
+		log.Infof("linked editing ranges: %s", pretty.Sprint(linkedEditingRanges))
+	}
+
+	for _, uri := range []string{helloURI, modURI} {
+		codeLenses, err := languageServer.CodeLenses(ctx, uri)
+		logIfError(err)
+		for _, codeLens := range codeLenses {
+			log.Infof("code lens: %s, line:%d, title:%s, command:%s", uri, codeLens.Range.Start.Line, codeLens.Command.Title, codeLens.Command.Command)
+		}
+	}
+
+	formatEdits, err := languageServer.Format(ctx, helloURI, protocol.FormattingOptions{TabSize: 4})
+	logIfError(err)
+	log.Infof("format edits: %d", len(formatEdits))
+
+	err = languageServer.Shutdown(ctx)
+	if err != nil {
+		log.Errorf("Shutdown failed. err: %s", err)
+	}
+	<-languageServer.Done()
 }
 
-func readData(filePath string) string {
-	fileData, err := ioutil.ReadFile(filePath)
+func logIfError(err error) {
 	if err != nil {
-		panic(err)
+		log.Errorf("call language server failed. err: %s", err)
 	}
-	return string(fileData)
 }
 
-type LSPHandler struct {
+func printCompletion(completionList *CompletionList, err error) {
+	if err != nil {
+		log.Errorf("textDocument/completion failed. err: %s", err)
+		return
+	}
+	log.Infof("textDocument/completion: %s", pretty.Sprint(completionList))
 }
 
-func (l *LSPHandler) Handle(context context.Context, conn *jsonrpc2.Conn, request *jsonrpc2.Request) {
-	result := make(map[string]interface{})
-	bytes := []byte(*request.Params)
-	json.Unmarshal(bytes, &result)
-	log.Infof("method:%s, message:%s", request.Method, pretty.Sprint(result["message"]))
+func readData(filePath string) string {
+	fileData, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		panic(err)
+	}
+	return string(fileData)
 }
 
 type Logger struct {
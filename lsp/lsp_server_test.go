@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"lsp/protocol"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkspacePath(t *testing.T) {
+	lsp := &LanguageServer{workspaceFolders: []protocol.WorkspaceFolder{{URI: "file:///tmp/ws/session/", Name: "session"}}}
+	tests := []struct {
+		uri  protocol.DocumentURI
+		path string
+	}{
+		{"file:///tmp/ws/session/hello.go", "/tmp/ws/session/hello.go"},
+		{"file:///tmp/ws/session/pkg/util.go", "/tmp/ws/session/pkg/util.go"},
+		{"file:///tmp/ws/session/pkg/../go.mod", "/tmp/ws/session/go.mod"},
+		{"file:///tmp/ws/session", ""},
+		{"file:///tmp/ws/session/../other/hello.go", ""},
+		{"file:///tmp/ws/session-other/hello.go", ""},
+		{"file:///etc/passwd", ""},
+		{"untitled:hello.go", ""},
+	}
+	for _, test := range tests {
+		path, err := lsp.workspacePath(test.uri)
+		if test.path == "" {
+			if err == nil {
+				t.Errorf("workspacePath(%s) = %s, want an error", test.uri, path)
+			}
+			continue
+		}
+		if err != nil || path != test.path {
+			t.Errorf("workspacePath(%s) = %s, %v, want %s", test.uri, path, err, test.path)
+		}
+	}
+}
+
+func TestDidChangeVersionedTextDocument(t *testing.T) {
+	lsp := newTestLanguageServer()
+	uri := "file:///workspace/main.go"
+	document, err := lsp.documents.open(protocol.DocumentURI(uri), "go", "package main\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := []protocol.TextDocumentContentChangeEvent{{Text: "package demo\n"}}
+
+	err = lsp.DidChangeVersionedTextDocument(context.Background(), uri, document.Version, changes)
+	if err == nil {
+		t.Fatal("changes to the version before the current one are applied")
+	}
+	err = lsp.DidChangeVersionedTextDocument(context.Background(), uri, document.Version+1, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, _ = lsp.Document(uri)
+	if document.Text != "package demo\n" {
+		t.Fatalf("text = %q", document.Text)
+	}
+}
+
+func TestPathToURI(t *testing.T) {
+	tests := []struct {
+		path string
+		uri  protocol.DocumentURI
+	}{
+		{"/tmp/ws/session/hello.go", "file:///tmp/ws/session/hello.go"},
+		{"/tmp/ws/my session/", "file:///tmp/ws/my%20session/"},
+		{"/tmp/ws/100%/go.mod", "file:///tmp/ws/100%25/go.mod"},
+	}
+	for _, test := range tests {
+		uri := pathToURI(test.path)
+		if uri != test.uri {
+			t.Errorf("pathToURI(%s) = %s, want %s", test.path, uri, test.uri)
+			continue
+		}
+		path, err := uriToPath(uri)
+		if err != nil || path != filepath.FromSlash(test.path) {
+			t.Errorf("uriToPath(%s) = %s, %v, want %s", uri, path, err, test.path)
+		}
+	}
+}
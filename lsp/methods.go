@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+)
+
+type methodKind int
+
+const (
+	methodRequest methodKind = iota + 1
+	methodNotification
+)
+
+func (k methodKind) String() string {
+	switch k {
+	case methodRequest:
+		return "request"
+	case methodNotification:
+		return "notification"
+	default:
+		return "unknown"
+	}
+}
+
+// clientMethods lists every client to server method LanguageServer may send and whether the server replies to it.
+var clientMethods = map[string]methodKind{
+	"initialize":  methodRequest,
+	"initialized": methodNotification,
+	"shutdown":    methodRequest,
+	"exit":        methodNotification,
+
+	"$/cancelRequest": methodNotification,
+	"$/setTrace":      methodNotification,
+	"$/progress":      methodNotification,
+
+	"window/workDoneProgress/cancel": methodNotification,
+
+	"workspace/didChangeConfiguration":    methodNotification,
+	"workspace/didChangeWatchedFiles":     methodNotification,
+	"workspace/didChangeWorkspaceFolders": methodNotification,
+	"workspace/didCreateFiles":            methodNotification,
+	"workspace/didRenameFiles":            methodNotification,
+	"workspace/didDeleteFiles":            methodNotification,
+	"workspace/willCreateFiles":           methodRequest,
+	"workspace/willRenameFiles":           methodRequest,
+	"workspace/willDeleteFiles":           methodRequest,
+	"workspace/symbol":                    methodRequest,
+	"workspace/executeCommand":            methodRequest,
+
+	"textDocument/didOpen":           methodNotification,
+	"textDocument/didChange":         methodNotification,
+	"textDocument/willSave":          methodNotification,
+	"textDocument/willSaveWaitUntil": methodRequest,
+	"textDocument/didSave":           methodNotification,
+	"textDocument/didClose":          methodNotification,
+
+	"textDocument/completion":                methodRequest,
+	"completionItem/resolve":                 methodRequest,
+	"textDocument/hover":                     methodRequest,
+	"textDocument/signatureHelp":             methodRequest,
+	"textDocument/declaration":               methodRequest,
+	"textDocument/definition":                methodRequest,
+	"textDocument/typeDefinition":            methodRequest,
+	"textDocument/implementation":            methodRequest,
+	"textDocument/references":                methodRequest,
+	"textDocument/documentHighlight":         methodRequest,
+	"textDocument/documentSymbol":            methodRequest,
+	"textDocument/codeAction":                methodRequest,
+	"codeAction/resolve":                     methodRequest,
+	"textDocument/codeLens":                  methodRequest,
+	"codeLens/resolve":                       methodRequest,
+	"textDocument/documentLink":              methodRequest,
+	"documentLink/resolve":                   methodRequest,
+	"textDocument/documentColor":             methodRequest,
+	"textDocument/colorPresentation":         methodRequest,
+	"textDocument/formatting":                methodRequest,
+	"textDocument/rangeFormatting":           methodRequest,
+	"textDocument/onTypeFormatting":          methodRequest,
+	"textDocument/rename":                    methodRequest,
+	"textDocument/prepareRename":             methodRequest,
+	"textDocument/foldingRange":              methodRequest,
+	"textDocument/selectionRange":            methodRequest,
+	"textDocument/linkedEditingRange":        methodRequest,
+	"textDocument/prepareCallHierarchy":      methodRequest,
+	"callHierarchy/incomingCalls":            methodRequest,
+	"callHierarchy/outgoingCalls":            methodRequest,
+	"textDocument/semanticTokens/full":       methodRequest,
+	"textDocument/semanticTokens/full/delta": methodRequest,
+	"textDocument/semanticTokens/range":      methodRequest,
+	"textDocument/moniker":                   methodRequest,
+}
+
+// MethodKindError is returned when a request method is sent as a notification or the other way round.
+type MethodKindError struct {
+	Method string
+	Want   methodKind
+	Got    methodKind
+}
+
+func (e *MethodKindError) Error() string {
+	if e.Got == 0 {
+		return fmt.Sprintf("lsp %s: unknown method, cannot send it as a %s", e.Method, e.Want)
+	}
+	return fmt.Sprintf("lsp %s: method is a %s, cannot send it as a %s", e.Method, e.Got, e.Want)
+}
+
+func checkMethodKind(method string, want methodKind) error {
+	got := clientMethods[method]
+	if got != want {
+		return &MethodKindError{Method: method, Want: want, Got: got}
+	}
+	return nil
+}
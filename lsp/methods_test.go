@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestClientMethodsTable(t *testing.T) {
+	for method, kind := range clientMethods {
+		if kind != methodRequest && kind != methodNotification {
+			t.Errorf("%s: kind %d is neither a request nor a notification", method, kind)
+		}
+	}
+	for method := range methodCapabilities {
+		if clientMethods[method] != methodRequest {
+			t.Errorf("%s: has a server capability but is not a request in clientMethods", method)
+		}
+	}
+}
+
+func TestCheckMethodKind(t *testing.T) {
+	tests := []struct {
+		method string
+		want   methodKind
+		got    methodKind
+		ok     bool
+	}{
+		{"textDocument/hover", methodRequest, methodRequest, true},
+		{"textDocument/didOpen", methodNotification, methodNotification, true},
+		{"textDocument/didOpen", methodRequest, methodNotification, false},
+		{"textDocument/hover", methodNotification, methodRequest, false},
+		{"textDocument/unknown", methodRequest, 0, false},
+	}
+	for _, test := range tests {
+		err := checkMethodKind(test.method, test.want)
+		if test.ok {
+			if err != nil {
+				t.Errorf("checkMethodKind(%s, %s) = %v, want nil", test.method, test.want, err)
+			}
+			continue
+		}
+		var kindErr *MethodKindError
+		if !errors.As(err, &kindErr) {
+			t.Errorf("checkMethodKind(%s, %s) = %v, want a MethodKindError", test.method, test.want, err)
+			continue
+		}
+		if kindErr.Method != test.method || kindErr.Want != test.want || kindErr.Got != test.got {
+			t.Errorf("checkMethodKind(%s, %s) = %+v", test.method, test.want, kindErr)
+		}
+	}
+}
+
+func TestCallNotification(t *testing.T) {
+	lsp := &LanguageServer{}
+	err := lsp.call(context.Background(), "textDocument/didSave", nil, nil)
+	var kindErr *MethodKindError
+	if !errors.As(err, &kindErr) || kindErr.Got != methodNotification {
+		t.Fatalf("call of a notification = %v, want a MethodKindError", err)
+	}
+}
+
+func TestNotifyRequest(t *testing.T) {
+	lsp := &LanguageServer{}
+	err := lsp.notify(context.Background(), "textDocument/definition", nil)
+	var kindErr *MethodKindError
+	if !errors.As(err, &kindErr) || kindErr.Got != methodRequest {
+		t.Fatalf("notify of a request = %v, want a MethodKindError", err)
+	}
+}
+
+// TestSentMethodKinds checks every method the package sends with call or notify against the table.
+func TestSentMethodKinds(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) < 2 {
+				return true
+			}
+			selector, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			var want methodKind
+			switch selector.Sel.Name {
+			case "call":
+				want = methodRequest
+			case "notify":
+				want = methodNotification
+			default:
+				return true
+			}
+			literal, ok := call.Args[1].(*ast.BasicLit)
+			if !ok || literal.Kind != token.STRING {
+				return true
+			}
+			method, _ := strconv.Unquote(literal.Value)
+			if got := clientMethods[method]; got != want {
+				t.Errorf("%s: %s is sent as a %s but is a %s", fset.Position(call.Pos()), method, want, got)
+			}
+			return true
+		})
+	}
+}
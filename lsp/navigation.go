@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lsp/protocol"
+	"strings"
+	"unicode/utf16"
+)
+
+// SignatureHelp is textDocument/signatureHelp with documentation normalized to MarkupContent
+// and parameter labels resolved to text. protocol.SignatureHelp types those unions as strings.
+type SignatureHelp struct {
+	Signatures      []SignatureInformation `json:"signatures"`
+	ActiveSignature uint32                 `json:"activeSignature"`
+	ActiveParameter uint32                 `json:"activeParameter"`
+}
+
+type SignatureInformation struct {
+	Label         string                 `json:"label"`
+	Documentation protocol.MarkupContent `json:"documentation"`
+	Parameters    []ParameterInformation `json:"parameters,omitempty"`
+	// ActiveParameter overrides SignatureHelp.ActiveParameter when set.
+	ActiveParameter *uint32 `json:"activeParameter,omitempty"`
+}
+
+type ParameterInformation struct {
+	Label string `json:"label"`
+	// LabelOffsets are the UTF-16 offsets of Label inside the signature label, if the server sent them.
+	LabelOffsets  *[2]uint32             `json:"labelOffsets,omitempty"`
+	Documentation protocol.MarkupContent `json:"documentation"`
+}
+
+// Hover returns nil when there is nothing to show. Contents is always MarkupContent,
+// MarkedString and MarkedString[] results are converted to markdown.
+func (lsp *LanguageServer) Hover(ctx context.Context, uri string, line uint32, character uint32) (*protocol.Hover, error) {
+	hoverParams := protocol.HoverParams{}
+	hoverParams.TextDocumentPositionParams = textDocumentPosition(uri, line, character)
+
+	var raw json.RawMessage
+	err := lsp.call(ctx, "textDocument/hover", &hoverParams, &raw)
+	if err != nil {
+		return nil, err
+	}
+	return decodeHoverResult(raw)
+}
+
+func (lsp *LanguageServer) SignatureHelp(ctx context.Context, uri string, line uint32, character uint32) (*SignatureHelp, error) {
+	// protocol.SignatureHelpParams always sends a context, which would carry an invalid trigger kind.
+	signatureHelpParams := struct {
+		protocol.TextDocumentPositionParams
+	}{textDocumentPosition(uri, line, character)}
+
+	var raw json.RawMessage
+	err := lsp.call(ctx, "textDocument/signatureHelp", &signatureHelpParams, &raw)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSignatureHelpResult(raw)
+}
+
+// Definition, TypeDefinition and Implementation normalize `Location | Location[] | LocationLink[]`
+// to LocationLinks. A plain Location becomes a link whose target and selection ranges are equal.
+func (lsp *LanguageServer) Definition(ctx context.Context, uri string, line uint32, character uint32) ([]protocol.LocationLink, error) {
+	definitionParams := protocol.DefinitionParams{}
+	definitionParams.TextDocumentPositionParams = textDocumentPosition(uri, line, character)
+	return lsp.locationLinks(ctx, "textDocument/definition", &definitionParams)
+}
+
+func (lsp *LanguageServer) TypeDefinition(ctx context.Context, uri string, line uint32, character uint32) ([]protocol.LocationLink, error) {
+	typeDefinitionParams := protocol.TypeDefinitionParams{}
+	typeDefinitionParams.TextDocumentPositionParams = textDocumentPosition(uri, line, character)
+	return lsp.locationLinks(ctx, "textDocument/typeDefinition", &typeDefinitionParams)
+}
+
+func (lsp *LanguageServer) Implementation(ctx context.Context, uri string, line uint32, character uint32) ([]protocol.LocationLink, error) {
+	implementationParams := protocol.ImplementationParams{}
+	implementationParams.TextDocumentPositionParams = textDocumentPosition(uri, line, character)
+	return lsp.locationLinks(ctx, "textDocument/implementation", &implementationParams)
+}
+
+func (lsp *LanguageServer) References(ctx context.Context, uri string, line uint32, character uint32, includeDeclaration bool) ([]protocol.Location, error) {
+	referenceParams := protocol.ReferenceParams{}
+	referenceParams.TextDocumentPositionParams = textDocumentPosition(uri, line, character)
+	referenceParams.Context.IncludeDeclaration = includeDeclaration
+
+	var locations []protocol.Location
+	err := lsp.call(ctx, "textDocument/references", &referenceParams, &locations)
+	if err != nil {
+		return nil, err
+	}
+	if locations == nil {
+		locations = []protocol.Location{}
+	}
+	return locations, nil
+}
+
+func (lsp *LanguageServer) locationLinks(ctx context.Context, method string, params interface{}) ([]protocol.LocationLink, error) {
+	var raw json.RawMessage
+	err := lsp.call(ctx, method, params, &raw)
+	if err != nil {
+		return nil, err
+	}
+	links, err := decodeLocationLinks(raw)
+	if err != nil {
+		return nil, fmt.Errorf("lsp %s: decode result failed: %w", method, err)
+	}
+	return links, nil
+}
+
+func textDocumentPosition(uri string, line uint32, character uint32) protocol.TextDocumentPositionParams {
+	position := protocol.TextDocumentPositionParams{}
+	position.TextDocument.URI = protocol.DocumentURI(uri)
+	position.Position.Line = line
+	position.Position.Character = character
+	return position
+}
+
+// decodeLocationLinks accepts the `Location | Location[] | LocationLink[] | null` union.
+func decodeLocationLinks(raw json.RawMessage) ([]protocol.LocationLink, error) {
+	links := []protocol.LocationLink{}
+	if isNullResult(raw) {
+		return links, nil
+	}
+	if raw[0] != '[' {
+		raw = json.RawMessage("[" + string(raw) + "]")
+	}
+	var items []struct {
+		protocol.Location
+		protocol.LocationLink
+	}
+	err := json.Unmarshal(raw, &items)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.TargetURI != "" {
+			links = append(links, item.LocationLink)
+			continue
+		}
+		links = append(links, protocol.LocationLink{
+			TargetURI:            item.URI,
+			TargetRange:          item.Location.Range,
+			TargetSelectionRange: item.Location.Range,
+		})
+	}
+	return links, nil
+}
+
+func decodeHoverResult(raw json.RawMessage) (*protocol.Hover, error) {
+	if isNullResult(raw) {
+		return nil, nil
+	}
+	hover := struct {
+		Contents json.RawMessage `json:"contents"`
+		Range    protocol.Range  `json:"range"`
+	}{}
+	err := json.Unmarshal(raw, &hover)
+	if err != nil {
+		return nil, fmt.Errorf("lsp textDocument/hover: decode result failed: %w", err)
+	}
+	contents, err := decodeMarkup(hover.Contents, protocol.Markdown)
+	if err != nil {
+		return nil, fmt.Errorf("lsp textDocument/hover: decode contents failed: %w", err)
+	}
+	return &protocol.Hover{Contents: contents, Range: hover.Range}, nil
+}
+
+func decodeSignatureHelpResult(raw json.RawMessage) (*SignatureHelp, error) {
+	if isNullResult(raw) {
+		return nil, nil
+	}
+	result := struct {
+		Signatures []struct {
+			Label         string          `json:"label"`
+			Documentation json.RawMessage `json:"documentation"`
+			Parameters    []struct {
+				Label         json.RawMessage `json:"label"`
+				Documentation json.RawMessage `json:"documentation"`
+			} `json:"parameters"`
+			ActiveParameter *uint32 `json:"activeParameter"`
+		} `json:"signatures"`
+		ActiveSignature *uint32 `json:"activeSignature"`
+		ActiveParameter *uint32 `json:"activeParameter"`
+	}{}
+	err := json.Unmarshal(raw, &result)
+	if err != nil {
+		return nil, fmt.Errorf("lsp textDocument/signatureHelp: decode result failed: %w", err)
+	}
+
+	signatureHelp := SignatureHelp{Signatures: make([]SignatureInformation, 0, len(result.Signatures))}
+	if result.ActiveSignature != nil {
+		signatureHelp.ActiveSignature = *result.ActiveSignature
+	}
+	if result.ActiveParameter != nil {
+		signatureHelp.ActiveParameter = *result.ActiveParameter
+	}
+	for _, signature := range result.Signatures {
+		information := SignatureInformation{Label: signature.Label, ActiveParameter: signature.ActiveParameter}
+		information.Documentation, err = decodeMarkup(signature.Documentation, protocol.PlainText)
+		if err != nil {
+			return nil, fmt.Errorf("lsp textDocument/signatureHelp: decode documentation failed: %w", err)
+		}
+		for _, parameter := range signature.Parameters {
+			parameterInformation := ParameterInformation{}
+			if len(parameter.Label) > 0 && parameter.Label[0] == '[' {
+				offsets := [2]uint32{}
+				err = json.Unmarshal(parameter.Label, &offsets)
+				if err != nil {
+					return nil, fmt.Errorf("lsp textDocument/signatureHelp: decode parameter label failed: %w", err)
+				}
+				parameterInformation.LabelOffsets = &offsets
+				parameterInformation.Label = utf16Slice(signature.Label, offsets[0], offsets[1])
+			} else {
+				err = json.Unmarshal(parameter.Label, &parameterInformation.Label)
+				if err != nil {
+					return nil, fmt.Errorf("lsp textDocument/signatureHelp: decode parameter label failed: %w", err)
+				}
+			}
+			parameterInformation.Documentation, err = decodeMarkup(parameter.Documentation, protocol.PlainText)
+			if err != nil {
+				return nil, fmt.Errorf("lsp textDocument/signatureHelp: decode documentation failed: %w", err)
+			}
+			information.Parameters = append(information.Parameters, parameterInformation)
+		}
+		signatureHelp.Signatures = append(signatureHelp.Signatures, information)
+	}
+	return &signatureHelp, nil
+}
+
+// decodeMarkup accepts `string | MarkupContent | MarkedString | MarkedString[]`. A bare
+// string gets stringKind: plaintext for documentation, markdown for hover MarkedStrings.
+func decodeMarkup(raw json.RawMessage, stringKind protocol.MarkupKind) (protocol.MarkupContent, error) {
+	if isNullResult(raw) {
+		return protocol.MarkupContent{Kind: protocol.PlainText}, nil
+	}
+	switch raw[0] {
+	case '"':
+		var value string
+		err := json.Unmarshal(raw, &value)
+		return protocol.MarkupContent{Kind: stringKind, Value: value}, err
+	case '[':
+		var items []json.RawMessage
+		err := json.Unmarshal(raw, &items)
+		if err != nil {
+			return protocol.MarkupContent{}, err
+		}
+		values := make([]string, 0, len(items))
+		for _, item := range items {
+			content, err := decodeMarkup(item, protocol.Markdown)
+			if err != nil {
+				return protocol.MarkupContent{}, err
+			}
+			if content.Value != "" {
+				values = append(values, content.Value)
+			}
+		}
+		return protocol.MarkupContent{Kind: protocol.Markdown, Value: strings.Join(values, "\n\n")}, nil
+	}
+
+	content := struct {
+		Kind     protocol.MarkupKind `json:"kind"`
+		Language *string             `json:"language"`
+		Value    string              `json:"value"`
+	}{}
+	err := json.Unmarshal(raw, &content)
+	if err != nil {
+		return protocol.MarkupContent{}, err
+	}
+	if content.Language != nil {
+		return protocol.MarkupContent{Kind: protocol.Markdown, Value: "```" + *content.Language + "\n" + content.Value + "\n```"}, nil
+	}
+	if content.Kind == "" {
+		content.Kind = protocol.PlainText
+	}
+	return protocol.MarkupContent{Kind: content.Kind, Value: content.Value}, nil
+}
+
+// utf16Slice cuts text by UTF-16 code unit offsets, clamped to the text.
+func utf16Slice(text string, start, end uint32) string {
+	units := utf16.Encode([]rune(text))
+	if end > uint32(len(units)) {
+		end = uint32(len(units))
+	}
+	if start > end {
+		start = end
+	}
+	return string(utf16.Decode(units[start:end]))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/sourcegraph/jsonrpc2"
+	"lsp/protocol"
+	"time"
+)
+
+const connectionSubscriberBuffer = 16
+
+type ConnectionState string
+
+const (
+	ConnectionConnected    ConnectionState = "connected"
+	ConnectionLost         ConnectionState = "lost"
+	ConnectionReconnecting ConnectionState = "reconnecting"
+	ConnectionRestored     ConnectionState = "restored"
+	ConnectionFailed       ConnectionState = "failed"
+	ConnectionClosed       ConnectionState = "closed"
+)
+
+type ConnectionEvent struct {
+	State   ConnectionState `json:"state"`
+	Attempt int             `json:"attempt,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Time    time.Time       `json:"time"`
+}
+
+type connectionEvents struct {
+	subscribers *subscribers
+}
+
+func newConnectionEvents() *connectionEvents {
+	return &connectionEvents{subscribers: newSubscribers("connection", false)}
+}
+
+func (c *connectionEvents) publish(state ConnectionState, attempt int, err error) {
+	event := ConnectionEvent{State: state, Attempt: attempt, Time: time.Now()}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	c.subscribers.publish(event)
+}
+
+func (c *connectionEvents) subscribe() (<-chan ConnectionEvent, func()) {
+	subscriber := make(chan ConnectionEvent, connectionSubscriberBuffer)
+	return subscriber, c.subscribers.add(subscriber)
+}
+
+// SubscribeConnection streams connection state changes until the returned cancel func is called.
+func (lsp *LanguageServer) SubscribeConnection() (<-chan ConnectionEvent, func()) {
+	return lsp.connectionEvents.subscribe()
+}
+
+// superviseLoop waits for the connection to drop, reconnects with backoff and restores
+// the session: initialize, open documents and configuration.
+func (lsp *LanguageServer) superviseLoop(rpcConn *jsonrpc2.Conn) {
+	connectedAt := time.Now()
+	<-rpcConn.DisconnectNotify()
+
+	lsp.mutex.Lock()
+	if lsp.state >= StateShuttingDown {
+		lsp.mutex.Unlock()
+		lsp.connectionEvents.publish(ConnectionClosed, 0, nil)
+		return
+	}
+	restore := len(lsp.workspaceFolders) > 0
+	_ = lsp.transitionLocked(StateStarted, StateInitializing, StateInitialized)
+	lsp.mutex.Unlock()
+
+	log.Warnf("LanguageServer connection lost, reconnect. transport:%s", lsp.transport)
+	lsp.connectionEvents.publish(ConnectionLost, 0, ErrConnectionLost)
+	if time.Since(connectedAt) > stableConnectionTime {
+		lsp.restartBackoff.reset()
+	}
+
+	for attempt := 1; ; attempt++ {
+		maxAttempts := lsp.serverConfig.MaxReconnectAttempts
+		if maxAttempts > 0 && attempt > maxAttempts {
+			err := fmt.Errorf("%w: gave up after %d reconnect attempts", ErrConnectionLost, maxAttempts)
+			log.Errorf("LanguageServer reconnect failed. err: %s", err)
+			lsp.mutex.Lock()
+			lsp.exitLocked(err)
+			lsp.mutex.Unlock()
+			lsp.connectionEvents.publish(ConnectionFailed, attempt-1, err)
+			return
+		}
+
+		delay := lsp.restartBackoff.next()
+		log.Infof("LanguageServer reconnect in %s. attempt:%d", delay, attempt)
+		lsp.connectionEvents.publish(ConnectionReconnecting, attempt, nil)
+		select {
+		case <-lsp.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if lsp.State() >= StateShuttingDown {
+			return
+		}
+		conn, err := lsp.transport.open(lsp.ctx)
+		if err != nil {
+			log.Errorf("LanguageServer reconnect failed. transport:%s, attempt:%d, err: %s", lsp.transport, attempt, err)
+			continue
+		}
+		lsp.mutex.Lock()
+		if lsp.state >= StateShuttingDown {
+			lsp.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		lsp.connect(conn)
+		lsp.registrations = make(map[string]protocol.Registration)
+		lsp.mutex.Unlock()
+
+		log.Infof("LanguageServer reconnect success. transport:%s", lsp.transport)
+		lsp.connectionEvents.publish(ConnectionConnected, attempt, nil)
+		if !restore {
+			return
+		}
+		err = lsp.restoreSession(lsp.ctx)
+		if err != nil {
+			// The new connection is in place; closing it sends us through another round of this loop.
+			log.Errorf("LanguageServer restore session failed. err: %s", err)
+			lsp.closeConnection()
+			return
+		}
+		lsp.connectionEvents.publish(ConnectionRestored, attempt, nil)
+		return
+	}
+}
+
+// restoreSession brings a fresh server up to the state the old one was in.
+func (lsp *LanguageServer) restoreSession(ctx context.Context) error {
+	folders := lsp.WorkspaceFolders()
+	if len(folders) == 0 {
+		return nil
+	}
+	_, err := lsp.InitWorkSpace(ctx, folders[0].Name, folders[0].URI)
+	if err != nil {
+		return err
+	}
+
+	lsp.diagnostics.clear()
+	lsp.semanticTokens.reset()
+	lsp.codeLenses.refresh()
+
+	lsp.documentMutex.Lock()
+	defer lsp.documentMutex.Unlock()
+	for _, document := range lsp.documents.all() {
+		didOpenParam := protocol.DidOpenTextDocumentParams{}
+		didOpenParam.TextDocument.URI = document.URI
+		didOpenParam.TextDocument.Version = document.Version
+		didOpenParam.TextDocument.LanguageID = document.LanguageID
+		didOpenParam.TextDocument.Text = document.Text
+		err = lsp.notify(ctx, "textDocument/didOpen", didOpenParam)
+		if err != nil {
+			return err
+		}
+	}
+
+	return lsp.notify(ctx, "workspace/didChangeConfiguration", protocol.DidChangeConfigurationParams{Settings: lsp.settingsSnapshot()})
+}
+
+func (lsp *LanguageServer) closeConnection() {
+	client, err := lsp.client("")
+	if err != nil {
+		return
+	}
+	err = client.Close()
+	if err != nil && !isClosedError(err) {
+		log.Warnf("LanguageServer close rpc connection failed. err:%s", err)
+	}
+}
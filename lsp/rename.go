@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lsp/protocol"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PrepareRenameResult is the range that will be renamed and the text to offer as the new name.
+type PrepareRenameResult struct {
+	Range       protocol.Range `json:"range"`
+	Placeholder string         `json:"placeholder"`
+}
+
+// PrepareRename returns nil when there is nothing to rename at the position. A server answering
+// `{ defaultBehavior: true }` leaves it to us: the identifier under the cursor is used.
+func (lsp *LanguageServer) PrepareRename(ctx context.Context, uri string, line uint32, character uint32) (*PrepareRenameResult, error) {
+	prepareRenameParams := protocol.PrepareRenameParams{}
+	prepareRenameParams.TextDocumentPositionParams = textDocumentPosition(uri, line, character)
+
+	var raw json.RawMessage
+	err := lsp.call(ctx, "textDocument/prepareRename", &prepareRenameParams, &raw)
+	if err != nil {
+		return nil, err
+	}
+	if isNullResult(raw) {
+		return nil, nil
+	}
+
+	result := struct {
+		Range           *protocol.Range    `json:"range"`
+		Placeholder     string             `json:"placeholder"`
+		DefaultBehavior bool               `json:"defaultBehavior"`
+		Start           *protocol.Position `json:"start"`
+		End             *protocol.Position `json:"end"`
+	}{}
+	err = json.Unmarshal(raw, &result)
+	if err != nil {
+		return nil, fmt.Errorf("lsp textDocument/prepareRename: decode result failed: %w", err)
+	}
+
+	document, ok := lsp.documents.get(protocol.DocumentURI(uri))
+	switch {
+	case result.Range != nil:
+		prepareRename := PrepareRenameResult{Range: *result.Range, Placeholder: result.Placeholder}
+		if prepareRename.Placeholder == "" && ok {
+			prepareRename.Placeholder = rangeText(document.Text, prepareRename.Range)
+		}
+		return &prepareRename, nil
+	case result.Start != nil && result.End != nil:
+		prepareRename := PrepareRenameResult{Range: protocol.Range{Start: *result.Start, End: *result.End}}
+		if ok {
+			prepareRename.Placeholder = rangeText(document.Text, prepareRename.Range)
+		}
+		return &prepareRename, nil
+	case result.DefaultBehavior && ok:
+		return identifierAt(document.Text, protocol.Position{Line: line, Character: character}), nil
+	}
+	return nil, nil
+}
+
+// Rename returns the edit the server computed; apply it with ApplyWorkspaceEdit.
+func (lsp *LanguageServer) Rename(ctx context.Context, uri string, line uint32, character uint32, newName string) (*WorkspaceEdit, error) {
+	renameParams := protocol.RenameParams{NewName: newName}
+	renameParams.TextDocument.URI = protocol.DocumentURI(uri)
+	renameParams.Position.Line = line
+	renameParams.Position.Character = character
+
+	var raw json.RawMessage
+	err := lsp.call(ctx, "textDocument/rename", &renameParams, &raw)
+	if err != nil {
+		return nil, err
+	}
+	if isNullResult(raw) {
+		return nil, nil
+	}
+	edit := WorkspaceEdit{}
+	err = json.Unmarshal(raw, &edit)
+	if err != nil {
+		return nil, fmt.Errorf("lsp textDocument/rename: decode result failed: %w", err)
+	}
+	return &edit, nil
+}
+
+func rangeText(text string, textRange protocol.Range) string {
+	start, err := positionOffset(text, textRange.Start)
+	if err != nil {
+		return ""
+	}
+	end, err := positionOffset(text, textRange.End)
+	if err != nil || end < start {
+		return ""
+	}
+	return text[start:end]
+}
+
+// identifierAt finds the identifier touching position, or nil if there is none.
+func identifierAt(text string, position protocol.Position) *PrepareRenameResult {
+	offset, err := positionOffset(text, position)
+	if err != nil {
+		return nil
+	}
+	isIdentifier := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+
+	start := offset
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:start])
+		if !isIdentifier(r) {
+			break
+		}
+		start -= size
+	}
+	end := offset
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		if !isIdentifier(r) {
+			break
+		}
+		end += size
+	}
+	if start == end {
+		return nil
+	}
+	return &PrepareRenameResult{
+		Range:       protocol.Range{Start: offsetPosition(text, start), End: offsetPosition(text, end)},
+		Placeholder: text[start:end],
+	}
+}
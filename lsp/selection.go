@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lsp/protocol"
+)
+
+// SelectionRanges returns one selection range chain per position, innermost range first with
+// each Parent containing its child. Parents that do not contain their child are cut off.
+func (lsp *LanguageServer) SelectionRanges(ctx context.Context, uri string, positions ...protocol.Position) ([]protocol.SelectionRange, error) {
+	selectionRangeParams := protocol.SelectionRangeParams{Positions: positions}
+	selectionRangeParams.TextDocument.URI = protocol.DocumentURI(uri)
+
+	var result []protocol.SelectionRange
+	err := lsp.call(ctx, "textDocument/selectionRange", &selectionRangeParams, &result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) != 0 && len(result) != len(positions) {
+		return nil, fmt.Errorf("lsp textDocument/selectionRange: %d results for %d positions", len(result), len(positions))
+	}
+
+	selectionRanges := make([]protocol.SelectionRange, len(positions))
+	for i, position := range positions {
+		if len(result) == 0 {
+			selectionRanges[i] = protocol.SelectionRange{Range: protocol.Range{Start: position, End: position}}
+			continue
+		}
+		selectionRanges[i] = result[i]
+		for child := &selectionRanges[i]; child.Parent != nil; child = child.Parent {
+			if !rangeContains(child.Parent.Range, child.Range) {
+				child.Parent = nil
+				break
+			}
+		}
+	}
+	return selectionRanges, nil
+}
+
+// selectionRangeChain flattens a selection range into its ranges, innermost first.
+func selectionRangeChain(selectionRange protocol.SelectionRange) []protocol.Range {
+	var chain []protocol.Range
+	for current := &selectionRange; current != nil; current = current.Parent {
+		chain = append(chain, current.Range)
+	}
+	return chain
+}
+
+// LinkedEditingRanges returns the ranges that are edited together with the one at the
+// position, nil when there are none.
+func (lsp *LanguageServer) LinkedEditingRanges(ctx context.Context, uri string, line uint32, character uint32) (*protocol.LinkedEditingRanges, error) {
+	linkedEditingRangeParams := protocol.LinkedEditingRangeParams{}
+	linkedEditingRangeParams.TextDocumentPositionParams = textDocumentPosition(uri, line, character)
+
+	var raw json.RawMessage
+	err := lsp.call(ctx, "textDocument/linkedEditingRange", &linkedEditingRangeParams, &raw)
+	if err != nil {
+		return nil, err
+	}
+	if isNullResult(raw) {
+		return nil, nil
+	}
+	linkedEditingRanges := &protocol.LinkedEditingRanges{}
+	err = json.Unmarshal(raw, linkedEditingRanges)
+	if err != nil {
+		return nil, fmt.Errorf("lsp textDocument/linkedEditingRange: decode result failed: %w", err)
+	}
+	if len(linkedEditingRanges.Ranges) == 0 {
+		return nil, nil
+	}
+	return linkedEditingRanges, nil
+}
+
+func rangeContains(outer, inner protocol.Range) bool {
+	return comparePosition(outer.Start, inner.Start) <= 0 && comparePosition(inner.End, outer.End) <= 0
+}
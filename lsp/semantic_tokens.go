@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lsp/protocol"
+	"sort"
+	"sync"
+)
+
+// SemanticToken is one decoded token with an absolute position. StartChar and Length are
+// counted in UTF-16 code units like every other LSP position.
+type SemanticToken struct {
+	Line      uint32   `json:"line"`
+	StartChar uint32   `json:"startChar"`
+	Length    uint32   `json:"length"`
+	Type      string   `json:"type"`
+	Modifiers []string `json:"modifiers,omitempty"`
+}
+
+// semanticTokensCache keeps the last full result per document so later requests can ask
+// for a delta against it.
+type semanticTokensCache struct {
+	mutex   sync.Mutex
+	results map[protocol.DocumentURI]protocol.SemanticTokens
+}
+
+func newSemanticTokensCache() *semanticTokensCache {
+	return &semanticTokensCache{results: make(map[protocol.DocumentURI]protocol.SemanticTokens)}
+}
+
+func (c *semanticTokensCache) get(uri protocol.DocumentURI) (protocol.SemanticTokens, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	result, ok := c.results[uri]
+	return result, ok
+}
+
+func (c *semanticTokensCache) put(uri protocol.DocumentURI, result protocol.SemanticTokens) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if result.ResultID == "" {
+		delete(c.results, uri)
+		return
+	}
+	c.results[uri] = result
+}
+
+func (c *semanticTokensCache) forget(uri protocol.DocumentURI) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.results, uri)
+}
+
+func (c *semanticTokensCache) reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.results = make(map[protocol.DocumentURI]protocol.SemanticTokens)
+}
+
+// SemanticTokens returns the tokens of the whole document. After the first call it asks
+// for a delta when the server supports it and patches the previous result.
+func (lsp *LanguageServer) SemanticTokens(ctx context.Context, uri string) ([]SemanticToken, error) {
+	legend, err := lsp.semanticTokensLegend()
+	if err != nil {
+		return nil, err
+	}
+
+	documentURI := protocol.DocumentURI(uri)
+	previous, ok := lsp.semanticTokens.get(documentURI)
+	var result protocol.SemanticTokens
+	if ok && lsp.Supports("textDocument/semanticTokens/full/delta") {
+		result, err = lsp.semanticTokensDelta(ctx, documentURI, previous)
+	} else {
+		semanticTokensParams := protocol.SemanticTokensParams{}
+		semanticTokensParams.TextDocument.URI = documentURI
+		err = lsp.call(ctx, "textDocument/semanticTokens/full", &semanticTokensParams, &result)
+	}
+	if err != nil {
+		lsp.semanticTokens.forget(documentURI)
+		return nil, err
+	}
+	lsp.semanticTokens.put(documentURI, result)
+	return decodeSemanticTokens(result.Data, legend)
+}
+
+func (lsp *LanguageServer) SemanticTokensRange(ctx context.Context, uri string, tokensRange protocol.Range) ([]SemanticToken, error) {
+	legend, err := lsp.semanticTokensLegend()
+	if err != nil {
+		return nil, err
+	}
+	semanticTokensRangeParams := protocol.SemanticTokensRangeParams{Range: tokensRange}
+	semanticTokensRangeParams.TextDocument.URI = protocol.DocumentURI(uri)
+
+	result := protocol.SemanticTokens{}
+	err = lsp.call(ctx, "textDocument/semanticTokens/range", &semanticTokensRangeParams, &result)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSemanticTokens(result.Data, legend)
+}
+
+// semanticTokensDelta accepts both answers to full/delta: a SemanticTokensDelta to apply
+// to the previous result or a complete SemanticTokens.
+func (lsp *LanguageServer) semanticTokensDelta(ctx context.Context, uri protocol.DocumentURI, previous protocol.SemanticTokens) (protocol.SemanticTokens, error) {
+	semanticTokensDeltaParams := protocol.SemanticTokensDeltaParams{PreviousResultID: previous.ResultID}
+	semanticTokensDeltaParams.TextDocument.URI = uri
+
+	var raw json.RawMessage
+	err := lsp.call(ctx, "textDocument/semanticTokens/full/delta", &semanticTokensDeltaParams, &raw)
+	if err != nil {
+		return protocol.SemanticTokens{}, err
+	}
+	result := struct {
+		ResultID string                         `json:"resultId"`
+		Data     []uint32                       `json:"data"`
+		Edits    *[]protocol.SemanticTokensEdit `json:"edits"`
+	}{}
+	if !isNullResult(raw) {
+		err = json.Unmarshal(raw, &result)
+		if err != nil {
+			return protocol.SemanticTokens{}, fmt.Errorf("lsp textDocument/semanticTokens/full/delta: decode result failed: %w", err)
+		}
+	}
+	if result.Edits == nil {
+		return protocol.SemanticTokens{ResultID: result.ResultID, Data: result.Data}, nil
+	}
+	data, err := applySemanticTokensEdits(previous.Data, *result.Edits)
+	if err != nil {
+		return protocol.SemanticTokens{}, fmt.Errorf("lsp textDocument/semanticTokens/full/delta: %w", err)
+	}
+	return protocol.SemanticTokens{ResultID: result.ResultID, Data: data}, nil
+}
+
+// applySemanticTokensEdits applies edits whose offsets all refer to the original data.
+func applySemanticTokensEdits(data []uint32, edits []protocol.SemanticTokensEdit) ([]uint32, error) {
+	sorted := make([]protocol.SemanticTokensEdit, len(edits))
+	copy(sorted, edits)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start > sorted[j].Start })
+
+	result := make([]uint32, len(data))
+	copy(result, data)
+	for _, edit := range sorted {
+		if edit.Start > uint32(len(result)) || edit.DeleteCount > uint32(len(result))-edit.Start {
+			return nil, fmt.Errorf("semantic tokens edit %d+%d is out of %d", edit.Start, edit.DeleteCount, len(result))
+		}
+		end := edit.Start + edit.DeleteCount
+		patched := make([]uint32, 0, len(result)-int(edit.DeleteCount)+len(edit.Data))
+		patched = append(patched, result[:edit.Start]...)
+		patched = append(patched, edit.Data...)
+		result = append(patched, result[end:]...)
+	}
+	return result, nil
+}
+
+// decodeSemanticTokens turns the relative `line, startChar, length, type, modifiers`
+// groups into absolute tokens.
+func decodeSemanticTokens(data []uint32, legend protocol.SemanticTokensLegend) ([]SemanticToken, error) {
+	if len(data)%5 != 0 {
+		return nil, fmt.Errorf("semantic tokens data length %d is not a multiple of 5", len(data))
+	}
+	tokens := make([]SemanticToken, 0, len(data)/5)
+	var line, startChar uint32
+	for i := 0; i < len(data); i += 5 {
+		if data[i] > 0 {
+			line += data[i]
+			startChar = data[i+1]
+		} else {
+			startChar += data[i+1]
+		}
+		token := SemanticToken{Line: line, StartChar: startChar, Length: data[i+2]}
+		if int(data[i+3]) < len(legend.TokenTypes) {
+			token.Type = legend.TokenTypes[data[i+3]]
+		}
+		for bit, modifier := range legend.TokenModifiers {
+			if data[i+4]&(1<<uint(bit)) != 0 {
+				token.Modifiers = append(token.Modifiers, modifier)
+			}
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// semanticTokensLegend reads the legend from the initialize result or a dynamic registration.
+func (lsp *LanguageServer) semanticTokensLegend() (protocol.SemanticTokensLegend, error) {
+	lsp.mutex.Lock()
+	options, ok := lookupOption(lsp.capabilities.raw, []string{"semanticTokensProvider"})
+	if !ok {
+		for _, registration := range lsp.registrations {
+			if registration.Method == "textDocument/semanticTokens" {
+				options, ok = registration.RegisterOptions, true
+			}
+		}
+	}
+	lsp.mutex.Unlock()
+
+	legend := struct {
+		Legend protocol.SemanticTokensLegend `json:"legend"`
+	}{}
+	if ok {
+		data, err := json.Marshal(options)
+		if err == nil {
+			err = json.Unmarshal(data, &legend)
+		}
+		if err != nil {
+			return protocol.SemanticTokensLegend{}, fmt.Errorf("decode semantic tokens legend failed: %w", err)
+		}
+	}
+	if len(legend.Legend.TokenTypes) == 0 {
+		return protocol.SemanticTokensLegend{}, &UnsupportedMethodError{Method: "textDocument/semanticTokens/full"}
+	}
+	return legend.Legend, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"lsp/protocol"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestApplySemanticTokensEdits(t *testing.T) {
+	data := []uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	tests := []struct {
+		name  string
+		edits []protocol.SemanticTokensEdit
+		want  []uint32
+	}{
+		{"no edits", nil, data},
+		{"replace", []protocol.SemanticTokensEdit{{Start: 5, DeleteCount: 5, Data: []uint32{50, 60}}}, []uint32{0, 1, 2, 3, 4, 50, 60}},
+		{"insert at the end", []protocol.SemanticTokensEdit{{Start: 10, Data: []uint32{10}}}, []uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{"delete at the start", []protocol.SemanticTokensEdit{{Start: 0, DeleteCount: 5}}, []uint32{5, 6, 7, 8, 9}},
+		{"several edits", []protocol.SemanticTokensEdit{
+			{Start: 1, DeleteCount: 1, Data: []uint32{10, 11}},
+			{Start: 8, DeleteCount: 2},
+			{Start: 4, Data: []uint32{40}},
+		}, []uint32{0, 10, 11, 2, 3, 40, 4, 5, 6, 7}},
+		{"several edits out of order", []protocol.SemanticTokensEdit{
+			{Start: 8, DeleteCount: 2},
+			{Start: 4, Data: []uint32{40}},
+			{Start: 1, DeleteCount: 1, Data: []uint32{10, 11}},
+		}, []uint32{0, 10, 11, 2, 3, 40, 4, 5, 6, 7}},
+		{"start out of range", []protocol.SemanticTokensEdit{{Start: 11, Data: []uint32{1}}}, nil},
+		{"delete out of range", []protocol.SemanticTokensEdit{{Start: 8, DeleteCount: 3}}, nil},
+		{"delete count overflow", []protocol.SemanticTokensEdit{{Start: 1, DeleteCount: math.MaxUint32}}, nil},
+		{"one edit of several out of range", []protocol.SemanticTokensEdit{{Start: 0, DeleteCount: 1}, {Start: 20}}, nil},
+	}
+	for _, test := range tests {
+		got, err := applySemanticTokensEdits(data, test.edits)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%s: applySemanticTokensEdits = %v, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: applySemanticTokensEdits = %v, %v, want %v", test.name, got, err, test.want)
+		}
+	}
+	if !reflect.DeepEqual(data, []uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Errorf("applySemanticTokensEdits changed its input to %v", data)
+	}
+}
+
+func TestDecodeSemanticTokens(t *testing.T) {
+	legend := protocol.SemanticTokensLegend{
+		TokenTypes:     []string{"keyword", "function", "variable"},
+		TokenModifiers: []string{"declaration", "readonly", "static"},
+	}
+	data := []uint32{
+		0, 0, 7, 0, 0,
+		2, 5, 4, 1, 1,
+		0, 6, 3, 2, 6,
+		3, 1, 1, 9, 8,
+	}
+	want := []SemanticToken{
+		{Line: 0, StartChar: 0, Length: 7, Type: "keyword"},
+		{Line: 2, StartChar: 5, Length: 4, Type: "function", Modifiers: []string{"declaration"}},
+		{Line: 2, StartChar: 11, Length: 3, Type: "variable", Modifiers: []string{"readonly", "static"}},
+		{Line: 5, StartChar: 1, Length: 1},
+	}
+	tokens, err := decodeSemanticTokens(data, legend)
+	if err != nil || !reflect.DeepEqual(tokens, want) {
+		t.Fatalf("decodeSemanticTokens = %+v, %v, want %+v", tokens, err, want)
+	}
+
+	_, err = decodeSemanticTokens(data[:7], legend)
+	if err == nil {
+		t.Fatal("decodeSemanticTokens of a partial token succeeded")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"lsp/protocol"
+)
+
+// capabilityPath locates the server capability behind a request method. The method is
+// supported when the provider is advertised in ServerCapabilities, or registered
+// dynamically under registerMethod, and every option along options is set.
+type capabilityPath struct {
+	registerMethod string
+	provider       []string
+	options        []string
+}
+
+var methodCapabilities = map[string]capabilityPath{
+	"textDocument/completion":                {"textDocument/completion", []string{"completionProvider"}, nil},
+	"completionItem/resolve":                 {"textDocument/completion", []string{"completionProvider"}, []string{"resolveProvider"}},
+	"textDocument/hover":                     {"textDocument/hover", []string{"hoverProvider"}, nil},
+	"textDocument/signatureHelp":             {"textDocument/signatureHelp", []string{"signatureHelpProvider"}, nil},
+	"textDocument/declaration":               {"textDocument/declaration", []string{"declarationProvider"}, nil},
+	"textDocument/definition":                {"textDocument/definition", []string{"definitionProvider"}, nil},
+	"textDocument/typeDefinition":            {"textDocument/typeDefinition", []string{"typeDefinitionProvider"}, nil},
+	"textDocument/implementation":            {"textDocument/implementation", []string{"implementationProvider"}, nil},
+	"textDocument/references":                {"textDocument/references", []string{"referencesProvider"}, nil},
+	"textDocument/documentHighlight":         {"textDocument/documentHighlight", []string{"documentHighlightProvider"}, nil},
+	"textDocument/documentSymbol":            {"textDocument/documentSymbol", []string{"documentSymbolProvider"}, nil},
+	"textDocument/codeAction":                {"textDocument/codeAction", []string{"codeActionProvider"}, nil},
+	"codeAction/resolve":                     {"textDocument/codeAction", []string{"codeActionProvider"}, []string{"resolveProvider"}},
+	"textDocument/codeLens":                  {"textDocument/codeLens", []string{"codeLensProvider"}, nil},
+	"codeLens/resolve":                       {"textDocument/codeLens", []string{"codeLensProvider"}, []string{"resolveProvider"}},
+	"textDocument/documentLink":              {"textDocument/documentLink", []string{"documentLinkProvider"}, nil},
+	"documentLink/resolve":                   {"textDocument/documentLink", []string{"documentLinkProvider"}, []string{"resolveProvider"}},
+	"textDocument/documentColor":             {"textDocument/documentColor", []string{"colorProvider"}, nil},
+	"textDocument/colorPresentation":         {"textDocument/documentColor", []string{"colorProvider"}, nil},
+	"textDocument/formatting":                {"textDocument/formatting", []string{"documentFormattingProvider"}, nil},
+	"textDocument/rangeFormatting":           {"textDocument/rangeFormatting", []string{"documentRangeFormattingProvider"}, nil},
+	"textDocument/onTypeFormatting":          {"textDocument/onTypeFormatting", []string{"documentOnTypeFormattingProvider"}, nil},
+	"textDocument/rename":                    {"textDocument/rename", []string{"renameProvider"}, nil},
+	"textDocument/prepareRename":             {"textDocument/rename", []string{"renameProvider"}, []string{"prepareProvider"}},
+	"textDocument/foldingRange":              {"textDocument/foldingRange", []string{"foldingRangeProvider"}, nil},
+	"textDocument/selectionRange":            {"textDocument/selectionRange", []string{"selectionRangeProvider"}, nil},
+	"textDocument/linkedEditingRange":        {"textDocument/linkedEditingRange", []string{"linkedEditingRangeProvider"}, nil},
+	"textDocument/prepareCallHierarchy":      {"textDocument/prepareCallHierarchy", []string{"callHierarchyProvider"}, nil},
+	"callHierarchy/incomingCalls":            {"textDocument/prepareCallHierarchy", []string{"callHierarchyProvider"}, nil},
+	"callHierarchy/outgoingCalls":            {"textDocument/prepareCallHierarchy", []string{"callHierarchyProvider"}, nil},
+	"textDocument/semanticTokens/full":       {"textDocument/semanticTokens", []string{"semanticTokensProvider"}, []string{"full"}},
+	"textDocument/semanticTokens/full/delta": {"textDocument/semanticTokens", []string{"semanticTokensProvider"}, []string{"full", "delta"}},
+	"textDocument/semanticTokens/range":      {"textDocument/semanticTokens", []string{"semanticTokensProvider"}, []string{"range"}},
+	"textDocument/moniker":                   {"textDocument/moniker", []string{"monikerProvider"}, nil},
+	"workspace/symbol":                       {"workspace/symbol", []string{"workspaceSymbolProvider"}, nil},
+	"workspace/executeCommand":               {"workspace/executeCommand", []string{"executeCommandProvider"}, nil},
+	"workspace/willCreateFiles":              {"workspace/willCreateFiles", []string{"workspace", "fileOperations", "willCreate"}, nil},
+	"workspace/willRenameFiles":              {"workspace/willRenameFiles", []string{"workspace", "fileOperations", "willRename"}, nil},
+	"workspace/willDeleteFiles":              {"workspace/willDeleteFiles", []string{"workspace", "fileOperations", "willDelete"}, nil},
+}
+
+// UnsupportedMethodError is returned instead of sending a request the server did not advertise.
+type UnsupportedMethodError struct {
+	Method string
+}
+
+func (e *UnsupportedMethodError) Error() string {
+	return fmt.Sprintf("lsp %s: not supported by the language server", e.Method)
+}
+
+// serverCapabilities keeps the capabilities from initialize both typed and as raw JSON,
+// the latter because several providers are `boolean | Options` unions.
+type serverCapabilities struct {
+	typed protocol.ServerCapabilities
+	raw   map[string]interface{}
+}
+
+func decodeInitializeResult(data json.RawMessage) (*protocol.InitializeResult, serverCapabilities, error) {
+	result := protocol.InitializeResult{}
+	err := json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, serverCapabilities{}, fmt.Errorf("lsp initialize: decode result failed: %w", err)
+	}
+	raw := struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}{}
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, serverCapabilities{}, fmt.Errorf("lsp initialize: decode capabilities failed: %w", err)
+	}
+	return &result, serverCapabilities{typed: result.Capabilities, raw: raw.Capabilities}, nil
+}
+
+// lookupOption walks a path of JSON object keys. A `true` in place of an options
+// object enables the capability without any of its options.
+func lookupOption(value interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = values[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, isEnabled(value)
+}
+
+func isEnabled(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	default:
+		return true
+	}
+}
+
+// Supports reports whether the server handles the given request method, either from its
+// initialize capabilities or from a later client/registerCapability. Methods that are not
+// tied to a capability, such as shutdown or the text synchronization notifications, are
+// always supported.
+func (lsp *LanguageServer) Supports(method string) bool {
+	path, ok := methodCapabilities[method]
+	if !ok {
+		return true
+	}
+
+	lsp.mutex.Lock()
+	defer lsp.mutex.Unlock()
+	if provider, ok := lookupOption(lsp.capabilities.raw, path.provider); ok {
+		if _, ok := lookupOption(provider, path.options); ok {
+			return true
+		}
+	}
+	for _, registration := range lsp.registrations {
+		if registration.Method != path.registerMethod {
+			continue
+		}
+		if len(path.options) == 0 {
+			return true
+		}
+		if _, ok := lookupOption(registration.RegisterOptions, path.options); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (lsp *LanguageServer) ServerCapabilities() protocol.ServerCapabilities {
+	lsp.mutex.Lock()
+	defer lsp.mutex.Unlock()
+	return lsp.capabilities.typed
+}
+
+// documentSyncKind prefers a dynamic textDocument/didChange registration over the initialize result.
+func (lsp *LanguageServer) documentSyncKind() protocol.TextDocumentSyncKind {
+	lsp.mutex.Lock()
+	defer lsp.mutex.Unlock()
+	for _, registration := range lsp.registrations {
+		if registration.Method != "textDocument/didChange" {
+			continue
+		}
+		if syncKind, ok := lookupOption(registration.RegisterOptions, []string{"syncKind"}); ok {
+			if kind, ok := syncKind.(float64); ok {
+				return protocol.TextDocumentSyncKind(kind)
+			}
+		}
+	}
+	return textDocumentSyncKind(lsp.capabilities.typed)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrTooManySessions is returned when a new session would exceed SessionConfig.MaxSessions.
+var ErrTooManySessions = errors.New("too many sessions")
+
+var sessionIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+type SessionConfig struct {
+	// WorkspaceRoot holds one workspace directory per session, removed when the session ends.
+	WorkspaceRoot string
+	// MaxSessions of 0 does not limit the number of sessions.
+	MaxSessions int
+	// IdleTimeout shuts down sessions without editor activity for that long, 0 never does.
+	IdleTimeout time.Duration
+
+	// Server is the language server started for every session.
+	Server ServerConfig
+	// Files maps the file names of a new workspace to the template files they are copied from.
+	Files map[string]string
+	// Documents are the files the editor opens when it connects, the first one is shown.
+	Documents []string
+	// LanguageIDs maps file names to language ids, the default is the extension.
+	LanguageIDs map[string]string
+}
+
+// Session is the workspace and language server of one browser.
+type Session struct {
+	ID      string
+	Dir     string
+	RootURI string
+	Server  *LanguageServer
+
+	ready      chan struct{}
+	err        error
+	lastActive time.Time
+}
+
+// sessionManager hands out one session per browser, limits how many run at once and shuts
+// down the idle ones through the shutdown/exit handshake.
+type sessionManager struct {
+	ctx    context.Context
+	config SessionConfig
+
+	mutex    sync.Mutex
+	sessions map[string]*Session
+	closed   bool
+}
+
+func newSessionManager(ctx context.Context, config SessionConfig) *sessionManager {
+	manager := &sessionManager{ctx: ctx, config: config, sessions: make(map[string]*Session)}
+	if config.IdleTimeout > 0 {
+		go manager.reapLoop()
+	}
+	return manager
+}
+
+func newSessionID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("generate session id failed: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func validSessionID(id string) bool {
+	return sessionIDPattern.MatchString(id)
+}
+
+// acquire returns the session with the id, starting it first if it does not run yet.
+func (m *sessionManager) acquire(id string) (*Session, error) {
+	if !validSessionID(id) {
+		return nil, fmt.Errorf("invalid session id %q", id)
+	}
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return nil, errors.New("session manager is closed")
+	}
+	session, ok := m.sessions[id]
+	if !ok {
+		if m.config.MaxSessions > 0 && len(m.sessions) >= m.config.MaxSessions {
+			m.mutex.Unlock()
+			return nil, ErrTooManySessions
+		}
+		session = &Session{ID: id, ready: make(chan struct{})}
+		m.sessions[id] = session
+	}
+	session.lastActive = time.Now()
+	m.mutex.Unlock()
+
+	if !ok {
+		session.err = m.start(session)
+		if session.err != nil {
+			m.remove(session)
+		}
+		close(session.ready)
+	}
+	<-session.ready
+	if session.err != nil {
+		return nil, session.err
+	}
+	return session, nil
+}
+
+// touch records editor activity, sessions are idle when they have not been touched for
+// IdleTimeout whether editors are still connected or not.
+func (m *sessionManager) touch(session *Session) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	session.lastActive = time.Now()
+}
+
+func (m *sessionManager) remove(session *Session) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.sessions[session.ID] == session {
+		delete(m.sessions, session.ID)
+	}
+}
+
+// start creates the workspace directory from the templates and initializes a language
+// server on it. The directory is removed once the server has exited.
+func (m *sessionManager) start(session *Session) error {
+	dir, err := ioutil.TempDir(m.config.WorkspaceRoot, session.ID+"-")
+	if err != nil {
+		return fmt.Errorf("create workspace of session %s failed: %w", session.ID, err)
+	}
+	for name, template := range m.config.Files {
+		data, err := ioutil.ReadFile(template)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(dir, name), data, 0644)
+		}
+		if err != nil {
+			_ = os.RemoveAll(dir)
+			return fmt.Errorf("create %s in workspace of session %s failed: %w", name, session.ID, err)
+		}
+	}
+	session.Dir = dir
+	session.RootURI = string(pathToURI(dir + string(filepath.Separator)))
+
+	serverConfig := m.config.Server
+	serverConfig.Dir = dir
+	session.Server = InitLanguageServer(m.ctx, serverConfig)
+	err = session.Server.Start()
+	if err == nil {
+		_, err = session.Server.InitWorkSpace(m.ctx, session.ID, session.RootURI)
+		if err != nil {
+			_ = session.Server.Shutdown(context.Background())
+		}
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return fmt.Errorf("start language server of session %s failed: %w", session.ID, err)
+	}
+	log.Infof("sessionManager session started. id:%s, dir:%s", session.ID, dir)
+
+	go func() {
+		<-session.Server.Done()
+		m.remove(session)
+		err := os.RemoveAll(dir)
+		if err != nil {
+			log.Warnf("sessionManager remove workspace failed. id:%s, dir:%s, err: %s", session.ID, dir, err)
+		}
+		log.Infof("sessionManager session ended. id:%s, err: %v", session.ID, session.Server.Err())
+	}()
+	return nil
+}
+
+// documents returns the workspace files the editor opens when it connects. Documents that
+// are still open from an earlier editor come with their current text.
+func (m *sessionManager) documents(session *Session) []EditorDocument {
+	documents := make([]EditorDocument, 0, len(m.config.Documents))
+	for _, name := range m.config.Documents {
+		uri := pathToURI(filepath.Join(session.Dir, name))
+		if document, ok := session.Server.Document(string(uri)); ok {
+			documents = append(documents, EditorDocument{URI: uri, LanguageID: document.LanguageID, Text: document.Text})
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(session.Dir, name))
+		if err != nil {
+			log.Warnf("sessionManager read workspace file failed. id:%s, name:%s, err: %s", session.ID, name, err)
+			continue
+		}
+		languageID, ok := m.config.LanguageIDs[name]
+		if !ok {
+			languageID = filepath.Ext(name)
+			if languageID != "" {
+				languageID = languageID[1:]
+			}
+		}
+		documents = append(documents, EditorDocument{
+			URI:        uri,
+			LanguageID: languageID,
+			Text:       string(data),
+		})
+	}
+	return documents
+}
+
+func (m *sessionManager) reapLoop() {
+	interval := m.config.IdleTimeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, session := range m.idle(now) {
+				log.Infof("sessionManager session idle, shut it down. id:%s", session.ID)
+				go m.shutdown(session)
+			}
+		}
+	}
+}
+
+// idle takes the sessions without activity for IdleTimeout out of the manager.
+func (m *sessionManager) idle(now time.Time) []*Session {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var idle []*Session
+	for id, session := range m.sessions {
+		select {
+		case <-session.ready:
+		default:
+			continue
+		}
+		if now.Sub(session.lastActive) >= m.config.IdleTimeout {
+			delete(m.sessions, id)
+			idle = append(idle, session)
+		}
+	}
+	return idle
+}
+
+// shutdown stops the language server of the session. Editors still connected to it are
+// disconnected once the server is done.
+func (m *sessionManager) shutdown(session *Session) {
+	err := session.Server.Shutdown(context.Background())
+	if err != nil {
+		log.Warnf("sessionManager shutdown session failed. id:%s, err: %s", session.ID, err)
+	}
+	<-session.Server.Done()
+	_ = os.RemoveAll(session.Dir)
+}
+
+// close shuts down every session and refuses new ones.
+func (m *sessionManager) close() {
+	m.mutex.Lock()
+	m.closed = true
+	sessions := make([]*Session, 0, len(m.sessions))
+	for id, session := range m.sessions {
+		delete(m.sessions, id)
+		sessions = append(sessions, session)
+	}
+	m.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func(session *Session) {
+			defer wg.Done()
+			<-session.ready
+			if session.err == nil {
+				m.shutdown(session)
+			}
+		}(session)
+	}
+	wg.Wait()
+}
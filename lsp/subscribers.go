@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+)
+
+// subscribers fans events out to buffered channels without blocking the publisher. An event
+// is dropped for a subscriber whose channel is full. Coalescing subscribers only need to
+// know something happened, so a full channel already carries the news and nothing is logged.
+type subscribers struct {
+	name     string
+	coalesce bool
+	mutex    sync.Mutex
+	channels map[int]reflect.Value
+	nextID   int
+}
+
+func newSubscribers(name string, coalesce bool) *subscribers {
+	return &subscribers{name: name, coalesce: coalesce, channels: make(map[int]reflect.Value)}
+}
+
+// add registers channel, a bidirectional chan, and returns the func that unregisters and
+// closes it. The func may be called more than once.
+func (s *subscribers) add(channel interface{}) func() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := s.nextID
+	s.nextID++
+	s.channels[id] = reflect.ValueOf(channel)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			s.channels[id].Close()
+			delete(s.channels, id)
+		})
+	}
+}
+
+func (s *subscribers) publish(event interface{}) {
+	value := reflect.ValueOf(event)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, channel := range s.channels {
+		if !channel.TrySend(value) && !s.coalesce {
+			log.Warnf("%s subscriber is full, drop event. subscriber:%d", s.name, id)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lsp/protocol"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// WorkspaceSymbolPage is one page of a ranked workspace symbol search.
+type WorkspaceSymbolPage struct {
+	Query   string                       `json:"query"`
+	Offset  int                          `json:"offset"`
+	Total   int                          `json:"total"`
+	Symbols []protocol.SymbolInformation `json:"symbols"`
+}
+
+// workspaceSymbolCache keeps the ranked results of the last query so paging through them
+// does not ask the server again.
+type workspaceSymbolCache struct {
+	mutex   sync.Mutex
+	query   string
+	symbols []protocol.SymbolInformation
+}
+
+// DocumentSymbols returns the outline of a document as a tree. Servers answering with flat
+// SymbolInformation get their symbols nested by range.
+func (lsp *LanguageServer) DocumentSymbols(ctx context.Context, uri string) ([]protocol.DocumentSymbol, error) {
+	documentSymbolParams := protocol.DocumentSymbolParams{}
+	documentSymbolParams.TextDocument.URI = protocol.DocumentURI(uri)
+
+	var raw []json.RawMessage
+	err := lsp.call(ctx, "textDocument/documentSymbol", &documentSymbolParams, &raw)
+	if err != nil {
+		return nil, err
+	}
+	symbols, err := decodeDocumentSymbols(raw)
+	if err != nil {
+		return nil, fmt.Errorf("lsp textDocument/documentSymbol: decode result failed: %w", err)
+	}
+	return symbols, nil
+}
+
+// WorkspaceSymbols searches the workspace, ranks the matches by how well their name fits
+// query and returns limit of them starting at offset. Pages of the same query come from
+// the first answer.
+func (lsp *LanguageServer) WorkspaceSymbols(ctx context.Context, query string, offset, limit int) (*WorkspaceSymbolPage, error) {
+	lsp.workspaceSymbols.mutex.Lock()
+	symbols, cached := lsp.workspaceSymbols.symbols, lsp.workspaceSymbols.query == query && lsp.workspaceSymbols.symbols != nil
+	lsp.workspaceSymbols.mutex.Unlock()
+
+	if !cached || offset == 0 {
+		workspaceSymbolParams := protocol.WorkspaceSymbolParams{Query: query}
+		var result []protocol.SymbolInformation
+		err := lsp.call(ctx, "workspace/symbol", &workspaceSymbolParams, &result)
+		if err != nil {
+			return nil, err
+		}
+		symbols = rankSymbols(query, result)
+
+		lsp.workspaceSymbols.mutex.Lock()
+		lsp.workspaceSymbols.query = query
+		lsp.workspaceSymbols.symbols = symbols
+		lsp.workspaceSymbols.mutex.Unlock()
+	}
+
+	page := &WorkspaceSymbolPage{Query: query, Offset: offset, Total: len(symbols), Symbols: []protocol.SymbolInformation{}}
+	if offset < 0 || offset >= len(symbols) {
+		return page, nil
+	}
+	end := len(symbols)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	page.Symbols = symbols[offset:end]
+	return page, nil
+}
+
+// decodeDocumentSymbols accepts the `DocumentSymbol[] | SymbolInformation[] | null` union.
+func decodeDocumentSymbols(raw []json.RawMessage) ([]protocol.DocumentSymbol, error) {
+	if len(raw) == 0 {
+		return []protocol.DocumentSymbol{}, nil
+	}
+	probe := struct {
+		Location *protocol.Location `json:"location"`
+	}{}
+	err := json.Unmarshal(raw[0], &probe)
+	if err != nil {
+		return nil, err
+	}
+	if probe.Location == nil {
+		symbols := make([]protocol.DocumentSymbol, len(raw))
+		for i, item := range raw {
+			err = json.Unmarshal(item, &symbols[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return symbols, nil
+	}
+
+	flat := make([]protocol.SymbolInformation, len(raw))
+	for i, item := range raw {
+		err = json.Unmarshal(item, &flat[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return symbolTree(flat), nil
+}
+
+// symbolTree nests flat symbols by range: a symbol becomes the child of the innermost
+// symbol whose range contains it.
+func symbolTree(flat []protocol.SymbolInformation) []protocol.DocumentSymbol {
+	type node struct {
+		symbol   protocol.DocumentSymbol
+		children []*node
+	}
+	sorted := make([]protocol.SymbolInformation, len(flat))
+	copy(sorted, flat)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].Location.Range, sorted[j].Location.Range
+		if c := comparePosition(a.Start, b.Start); c != 0 {
+			return c < 0
+		}
+		return comparePosition(a.End, b.End) > 0
+	})
+
+	var roots []*node
+	var stack []*node
+	for _, information := range sorted {
+		current := &node{symbol: protocol.DocumentSymbol{
+			Name:           information.Name,
+			Detail:         information.ContainerName,
+			Kind:           information.Kind,
+			Tags:           information.Tags,
+			Deprecated:     information.Deprecated,
+			Range:          information.Location.Range,
+			SelectionRange: information.Location.Range,
+		}}
+		for len(stack) > 0 && comparePosition(stack[len(stack)-1].symbol.Range.End, current.symbol.Range.End) < 0 {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, current)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, current)
+		}
+		stack = append(stack, current)
+	}
+
+	var build func(nodes []*node) []protocol.DocumentSymbol
+	build = func(nodes []*node) []protocol.DocumentSymbol {
+		symbols := make([]protocol.DocumentSymbol, 0, len(nodes))
+		for _, n := range nodes {
+			symbol := n.symbol
+			if len(n.children) > 0 {
+				symbol.Children = build(n.children)
+			}
+			symbols = append(symbols, symbol)
+		}
+		return symbols
+	}
+	return build(roots)
+}
+
+// rankSymbols drops the symbols whose name does not fuzzy match query and orders the rest
+// best match first.
+func rankSymbols(query string, symbols []protocol.SymbolInformation) []protocol.SymbolInformation {
+	type scored struct {
+		symbol protocol.SymbolInformation
+		score  int
+	}
+	matches := make([]scored, 0, len(symbols))
+	for _, symbol := range symbols {
+		score, ok := fuzzyScore(query, symbol.Name)
+		if !ok && symbol.ContainerName != "" {
+			score, ok = fuzzyScore(query, symbol.ContainerName+"."+symbol.Name)
+		}
+		if ok {
+			matches = append(matches, scored{symbol: symbol, score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].symbol.Name < matches[j].symbol.Name
+	})
+	ranked := make([]protocol.SymbolInformation, 0, len(matches))
+	for _, match := range matches {
+		ranked = append(ranked, match.symbol)
+	}
+	return ranked
+}
+
+// fuzzyScore matches pattern as a case-insensitive subsequence of candidate. Consecutive
+// characters, word starts and matching case score higher, skipped characters lower.
+func fuzzyScore(pattern, candidate string) (int, bool) {
+	if pattern == "" {
+		return 0, true
+	}
+	patternRunes := []rune(pattern)
+	candidateRunes := []rune(candidate)
+
+	score, p, last := 0, 0, -1
+	for i, r := range candidateRunes {
+		if p == len(patternRunes) {
+			break
+		}
+		if unicode.ToLower(r) != unicode.ToLower(patternRunes[p]) {
+			continue
+		}
+		score++
+		if r == patternRunes[p] {
+			score++
+		}
+		if last >= 0 && i == last+1 {
+			score += 5
+		} else if last >= 0 {
+			score -= i - last - 1
+		}
+		if i == 0 || strings.ContainsRune("_./ ", candidateRunes[i-1]) ||
+			(unicode.IsUpper(r) && unicode.IsLower(candidateRunes[i-1])) {
+			score += 8
+		}
+		if last < 0 && i > 0 {
+			score -= i
+		}
+		last = i
+		p++
+	}
+	if p < len(patternRunes) {
+		return 0, false
+	}
+	if len(patternRunes) == len(candidateRunes) {
+		score += 10
+	}
+	return score, true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"lsp/protocol"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		candidate string
+		ok        bool
+	}{
+		{"", "anything", true},
+		{"fb", "FooBar", true},
+		{"foobar", "FooBar", true},
+		{"fmtpl", "fmt.Println", true},
+		{"𝒳y", "a𝒳xy", true},
+		{"bf", "FooBar", false},
+		{"foo", "fo", false},
+		{"x", "", false},
+	}
+	for _, test := range tests {
+		if _, ok := fuzzyScore(test.pattern, test.candidate); ok != test.ok {
+			t.Errorf("fuzzyScore(%q, %q) matches = %v, want %v", test.pattern, test.candidate, ok, test.ok)
+		}
+	}
+
+	// Each pattern scores better against the first candidate than against the second.
+	better := []struct {
+		pattern string
+		first   string
+		second  string
+	}{
+		{"Foo", "Foo", "FooBar"},
+		{"foo", "foobar", "fxoxo"},
+		{"fb", "FooBar", "Foobar"},
+		{"fb", "foo_bar", "foobar"},
+		{"Foo", "Foo", "foo"},
+		{"bar", "barFoo", "fooBar"},
+	}
+	for _, test := range better {
+		first, _ := fuzzyScore(test.pattern, test.first)
+		second, _ := fuzzyScore(test.pattern, test.second)
+		if first <= second {
+			t.Errorf("fuzzyScore(%q): %q scores %d, not better than %q with %d", test.pattern, test.first, first, test.second, second)
+		}
+	}
+}
+
+func TestRankSymbols(t *testing.T) {
+	symbols := []protocol.SymbolInformation{
+		{Name: "Baz"},
+		{Name: "Foobar"},
+		{Name: "FooBar"},
+		{Name: "Run", ContainerName: "Server"},
+	}
+	var names []string
+	for _, symbol := range rankSymbols("fb", symbols) {
+		names = append(names, symbol.Name)
+	}
+	want := []string{"FooBar", "Foobar"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("rankSymbols(fb) = %v, want %v", names, want)
+	}
+
+	ranked := rankSymbols("serverrun", symbols)
+	if len(ranked) != 1 || ranked[0].Name != "Run" {
+		t.Errorf("rankSymbols(serverrun) = %+v, want the symbol matched through its container", ranked)
+	}
+}
+
+func symbolRange(startLine, startCharacter, endLine, endCharacter uint32) protocol.Location {
+	return protocol.Location{Range: textEdit(startLine, startCharacter, endLine, endCharacter, "").Range}
+}
+
+// formatSymbolTree writes the tree as `name(children) name`.
+func formatSymbolTree(symbols []protocol.DocumentSymbol) string {
+	names := make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		name := symbol.Name
+		if len(symbol.Children) > 0 {
+			name += "(" + formatSymbolTree(symbol.Children) + ")"
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestSymbolTree(t *testing.T) {
+	flat := []protocol.SymbolInformation{
+		{Name: "main", Location: symbolRange(11, 0, 13, 1)},
+		{Name: "a", ContainerName: "T", Location: symbolRange(2, 1, 2, 6)},
+		{Name: "Run", Location: symbolRange(7, 0, 9, 1)},
+		{Name: "T", Location: symbolRange(1, 0, 5, 1)},
+		{Name: "b", ContainerName: "T", Location: symbolRange(3, 1, 5, 0)},
+		{Name: "c", ContainerName: "b", Location: symbolRange(4, 2, 5, 0)},
+		{Name: "v", Location: symbolRange(8, 1, 8, 5)},
+		{Name: "after", Location: symbolRange(9, 1, 9, 8)},
+		{Name: "x", Location: symbolRange(12, 1, 12, 2)},
+		{Name: "y", Location: symbolRange(12, 1, 12, 2)},
+	}
+	want := "T(a b(c)) Run(v) after main(x(y))"
+	tree := symbolTree(flat)
+	if got := formatSymbolTree(tree); got != want {
+		t.Fatalf("symbolTree = %s, want %s", got, want)
+	}
+	if tree[0].Children[0].Detail != "T" || tree[0].SelectionRange != tree[0].Range {
+		t.Errorf("symbolTree root = %+v", tree[0])
+	}
+	if got := symbolTree(nil); len(got) != 0 {
+		t.Errorf("symbolTree(nil) = %+v", got)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	TransportTCP   = "tcp"
+	TransportStdio = "stdio"
+
+	defaultRestartMinBackoff = 500 * time.Millisecond
+	defaultRestartMaxBackoff = 30 * time.Second
+	processExitTimeout       = 3 * time.Second
+	stableConnectionTime     = time.Minute
+)
+
+// transport opens a fresh byte stream to a language server each time it is called.
+type transport interface {
+	open(ctx context.Context) (io.ReadWriteCloser, error)
+	String() string
+}
+
+func newTransport(config ServerConfig) (transport, error) {
+	switch config.Transport {
+	case "", TransportTCP:
+		if config.Address == "" {
+			return nil, fmt.Errorf("tcp transport requires an address")
+		}
+		network := config.NetWork
+		if network == "" {
+			network = "tcp"
+		}
+		return &tcpTransport{network: network, address: config.Address}, nil
+	case TransportStdio:
+		if config.Command == "" {
+			return nil, fmt.Errorf("stdio transport requires a command")
+		}
+		return &stdioTransport{command: config.Command, args: config.Args, dir: config.Dir, env: config.Env}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q", config.Transport)
+	}
+}
+
+type tcpTransport struct {
+	network string
+	address string
+}
+
+func (t *tcpTransport) open(ctx context.Context) (io.ReadWriteCloser, error) {
+	dialer := net.Dialer{}
+	return dialer.DialContext(ctx, t.network, t.address)
+}
+
+func (t *tcpTransport) String() string {
+	return fmt.Sprintf("%s://%s", t.network, t.address)
+}
+
+type stdioTransport struct {
+	command string
+	args    []string
+	dir     string
+	env     []string
+}
+
+func (t *stdioTransport) open(ctx context.Context) (io.ReadWriteCloser, error) {
+	cmd := exec.CommandContext(ctx, t.command, t.args...)
+	cmd.Dir = t.dir
+	if len(t.env) > 0 {
+		cmd.Env = append(os.Environ(), t.env...)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	log.Infof("LanguageServer process started. command:%s, pid:%d", t.command, cmd.Process.Pid)
+
+	process := &processConn{
+		cmd:        cmd,
+		stdin:      stdin,
+		stdout:     stdout,
+		stdoutDone: make(chan struct{}),
+		stderrDone: make(chan struct{}),
+		closing:    make(chan struct{}),
+		exited:     make(chan struct{}),
+	}
+	go process.logStderr(stderr)
+	go process.wait()
+	return process, nil
+}
+
+func (t *stdioTransport) String() string {
+	return fmt.Sprintf("stdio://%s", t.command)
+}
+
+// processConn joins the stdin and stdout of a child process into one stream. The process is
+// only waited for once stdout and stderr are read to the end, cmd.Wait closes the pipes.
+type processConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+
+	stdoutDone     chan struct{}
+	stdoutDoneOnce sync.Once
+	stderrDone     chan struct{}
+	closing        chan struct{}
+	exited         chan struct{}
+	closeOnce      sync.Once
+}
+
+func (p *processConn) Read(b []byte) (int, error) {
+	n, err := p.stdout.Read(b)
+	if err != nil {
+		p.stdoutDoneOnce.Do(func() { close(p.stdoutDone) })
+	}
+	return n, err
+}
+
+func (p *processConn) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+func (p *processConn) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.closing)
+		err = p.stdin.Close()
+		select {
+		case <-p.exited:
+		case <-time.After(processExitTimeout):
+			log.Warnf("LanguageServer process did not exit in %s, kill it. pid:%d", processExitTimeout, p.cmd.Process.Pid)
+			if killErr := p.cmd.Process.Kill(); killErr != nil {
+				err = killErr
+			}
+			<-p.exited
+		}
+	})
+	return err
+}
+
+// wait reaps the process after its output is read. Once the stream is closed nobody reads
+// stdout any more, then only stderr is waited for.
+func (p *processConn) wait() {
+	select {
+	case <-p.stdoutDone:
+	case <-p.closing:
+	}
+	<-p.stderrDone
+	err := p.cmd.Wait()
+	if err != nil {
+		log.Warnf("LanguageServer process exited. pid:%d, err: %s", p.cmd.Process.Pid, err)
+	} else {
+		log.Infof("LanguageServer process exited. pid:%d", p.cmd.Process.Pid)
+	}
+	close(p.exited)
+}
+
+func (p *processConn) logStderr(stderr io.Reader) {
+	defer close(p.stderrDone)
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		log.Infof("LanguageServer stderr: %s", scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		log.Warnf("LanguageServer read stderr failed, discard the rest. err: %s", err)
+		_, _ = io.Copy(ioutil.Discard, stderr)
+	}
+}
+
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	if min <= 0 {
+		min = defaultRestartMinBackoff
+	}
+	if max <= 0 {
+		max = defaultRestartMaxBackoff
+	}
+	if max < min {
+		max = min
+	}
+	return &backoff{min: min, max: max}
+}
+
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+	} else {
+		b.current *= 2
+		if b.current > b.max {
+			b.current = b.max
+		}
+	}
+	return b.current
+}
+
+func (b *backoff) reset() {
+	b.current = 0
+}
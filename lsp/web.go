@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	jsonrpc2websocket "github.com/sourcegraph/jsonrpc2/websocket"
+	"net/http"
+	"path/filepath"
+)
+
+const sessionCookie = "ide_session"
+
+// webServer serves the CodeMirror IDE from staticDir and bridges the editors connected on
+// /lsp to the language server of their browser session.
+type webServer struct {
+	ctx       context.Context
+	sessions  *sessionManager
+	staticDir string
+	upgrader  websocket.Upgrader
+}
+
+func newWebServer(ctx context.Context, sessions *sessionManager, staticDir string) *webServer {
+	return &webServer{ctx: ctx, sessions: sessions, staticDir: staticDir}
+}
+
+func (w *webServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/lsp", w.serveEditor)
+	files := http.FileServer(http.Dir(w.staticDir))
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/" {
+			_, err := w.sessionID(writer, request)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			http.ServeFile(writer, request, filepath.Join(w.staticDir, "ide.html"))
+			return
+		}
+		files.ServeHTTP(writer, request)
+	})
+	return mux
+}
+
+// sessionID reads the session of the browser from its cookie and gives it a new one if it
+// has none yet.
+func (w *webServer) sessionID(writer http.ResponseWriter, request *http.Request) (string, error) {
+	cookie, err := request.Cookie(sessionCookie)
+	if err == nil && validSessionID(cookie.Value) {
+		return cookie.Value, nil
+	}
+	id, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(writer, &http.Cookie{Name: sessionCookie, Value: id, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	return id, nil
+}
+
+func (w *webServer) serveEditor(writer http.ResponseWriter, request *http.Request) {
+	id, err := w.sessionID(writer, request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	session, err := w.sessions.acquire(id)
+	if errors.Is(err, ErrTooManySessions) {
+		log.Warnf("webServer reject editor, too many sessions. remote:%s", request.RemoteAddr)
+		http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Errorf("webServer acquire session failed. id:%s, err: %s", id, err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := w.upgrader.Upgrade(writer, request, writer.Header())
+	if err != nil {
+		log.Errorf("webServer upgrade websocket failed. remote:%s, err: %s", request.RemoteAddr, err)
+		return
+	}
+	log.Infof("webServer editor connected. session:%s, remote:%s", session.ID, request.RemoteAddr)
+	documents := func() []EditorDocument { return w.sessions.documents(session) }
+	activity := func() { w.sessions.touch(session) }
+	bridge := newEditorBridge(session.Server, session.RootURI, documents, activity)
+	bridge.serve(w.ctx, jsonrpc2websocket.NewObjectStream(conn))
+	w.sessions.touch(session)
+	log.Infof("webServer editor disconnected. session:%s, remote:%s", session.ID, request.RemoteAddr)
+}
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"lsp/protocol"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WorkspaceEdit is protocol.WorkspaceEdit as it is sent on the wire: documentChanges is the
+// `TextDocumentEdit | CreateFile | RenameFile | DeleteFile` union, edits may carry an
+// annotationId and changeAnnotations holds ChangeAnnotation objects.
+type WorkspaceEdit struct {
+	Changes           map[protocol.DocumentURI][]protocol.TextEdit `json:"changes,omitempty"`
+	DocumentChanges   []DocumentChange                             `json:"documentChanges,omitempty"`
+	ChangeAnnotations map[string]protocol.ChangeAnnotation         `json:"changeAnnotations,omitempty"`
+}
+
+// DocumentChange holds exactly one of its fields.
+type DocumentChange struct {
+	TextDocumentEdit *TextDocumentEdit
+	CreateFile       *protocol.CreateFile
+	RenameFile       *protocol.RenameFile
+	DeleteFile       *protocol.DeleteFile
+}
+
+type TextDocumentEdit struct {
+	TextDocument VersionedTextDocument        `json:"textDocument"`
+	Edits        []protocol.AnnotatedTextEdit `json:"edits"`
+}
+
+// VersionedTextDocument is OptionalVersionedTextDocumentIdentifier, a nil Version means any version.
+type VersionedTextDocument struct {
+	URI     protocol.DocumentURI `json:"uri"`
+	Version *int32               `json:"version"`
+}
+
+func (c DocumentChange) MarshalJSON() ([]byte, error) {
+	switch {
+	case c.TextDocumentEdit != nil:
+		return json.Marshal(c.TextDocumentEdit)
+	case c.CreateFile != nil:
+		return json.Marshal(c.CreateFile)
+	case c.RenameFile != nil:
+		return json.Marshal(c.RenameFile)
+	case c.DeleteFile != nil:
+		return json.Marshal(c.DeleteFile)
+	}
+	return nil, errors.New("empty document change")
+}
+
+func (c *DocumentChange) UnmarshalJSON(data []byte) error {
+	kind := struct {
+		Kind string `json:"kind"`
+	}{}
+	err := json.Unmarshal(data, &kind)
+	if err != nil {
+		return err
+	}
+	switch kind.Kind {
+	case "":
+		c.TextDocumentEdit = &TextDocumentEdit{}
+		return json.Unmarshal(data, c.TextDocumentEdit)
+	case "create":
+		c.CreateFile = &protocol.CreateFile{}
+		return json.Unmarshal(data, c.CreateFile)
+	case "rename":
+		c.RenameFile = &protocol.RenameFile{}
+		return json.Unmarshal(data, c.RenameFile)
+	case "delete":
+		c.DeleteFile = &protocol.DeleteFile{}
+		return json.Unmarshal(data, c.DeleteFile)
+	}
+	return fmt.Errorf("unknown document change kind %q", kind.Kind)
+}
+
+const documentEditSubscriberBuffer = 64
+
+// DocumentEditEvent is what a workspace edit did to an open document. The changes apply one
+// after another to the version before Version, a change without a range replaces the text.
+// Text is the whole text of Version for editors whose copy is not at the version before.
+// Documents opened by the edit carry their language, closed ones are no longer open under URI.
+type DocumentEditEvent struct {
+	URI        protocol.DocumentURI                      `json:"uri"`
+	Version    int32                                     `json:"version,omitempty"`
+	LanguageID string                                    `json:"languageId,omitempty"`
+	Changes    []protocol.TextDocumentContentChangeEvent `json:"changes,omitempty"`
+	Text       string                                    `json:"text,omitempty"`
+	Closed     bool                                      `json:"closed,omitempty"`
+}
+
+// SubscribeDocumentEdits streams the changes workspace edits make to open documents, rolled
+// back ones included, until the returned cancel func is called. Editors apply them to stay
+// in sync with the document store.
+func (lsp *LanguageServer) SubscribeDocumentEdits() (<-chan DocumentEditEvent, func()) {
+	subscriber := make(chan DocumentEditEvent, documentEditSubscriberBuffer)
+	return subscriber, lsp.documentEdits.add(subscriber)
+}
+
+// WorkspaceEditError reports which change of the edit could not be applied. Nothing of the
+// edit is left behind when it is returned.
+type WorkspaceEditError struct {
+	FailedChange int
+	Err          error
+}
+
+func (e *WorkspaceEditError) Error() string {
+	return fmt.Sprintf("apply workspace edit change %d failed: %s", e.FailedChange, e.Err)
+}
+
+func (e *WorkspaceEditError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyWorkspaceEdit applies the edit as a whole or not at all. Open documents are changed
+// in the document store and on the server, everything else on disk. DocumentChanges wins
+// over Changes when both are set. Editor changes wait until the edit is done, so the open
+// documents stay at the text the edit was staged on.
+func (lsp *LanguageServer) ApplyWorkspaceEdit(ctx context.Context, edit WorkspaceEdit) error {
+	lsp.documentMutex.Lock()
+	defer lsp.documentMutex.Unlock()
+
+	plan := &editPlan{ctx: ctx, lsp: lsp, files: make(map[string]*stagedFile)}
+	for i, change := range edit.documentChanges() {
+		err := plan.stage(i, change, edit.ChangeAnnotations)
+		if err != nil {
+			return &WorkspaceEditError{FailedChange: i, Err: err}
+		}
+	}
+	return plan.commit()
+}
+
+func (edit WorkspaceEdit) documentChanges() []DocumentChange {
+	if len(edit.DocumentChanges) > 0 {
+		return edit.DocumentChanges
+	}
+	uris := make([]string, 0, len(edit.Changes))
+	for uri := range edit.Changes {
+		uris = append(uris, string(uri))
+	}
+	sort.Strings(uris)
+	changes := make([]DocumentChange, 0, len(uris))
+	for _, uri := range uris {
+		documentEdit := &TextDocumentEdit{TextDocument: VersionedTextDocument{URI: protocol.DocumentURI(uri)}}
+		for _, textEdit := range edit.Changes[protocol.DocumentURI(uri)] {
+			documentEdit.Edits = append(documentEdit.Edits, protocol.AnnotatedTextEdit{TextEdit: textEdit})
+		}
+		changes = append(changes, DocumentChange{TextDocumentEdit: documentEdit})
+	}
+	return changes
+}
+
+// stagedFile is what a path looks like once the changes staged so far are applied.
+type stagedFile struct {
+	uri        protocol.DocumentURI
+	onDisk     bool
+	dir        bool
+	text       string
+	open       bool
+	languageID string
+	version    int32
+}
+
+func (f *stagedFile) exists() bool {
+	return f.onDisk || f.open
+}
+
+type editStep struct {
+	index    int
+	apply    func() error
+	undo     func() error
+	finalize func() error
+}
+
+// editPlan checks every change against a staged view of the workspace before anything is
+// touched, then commits the steps in order and undoes them if one of them still fails.
+type editPlan struct {
+	ctx   context.Context
+	lsp   *LanguageServer
+	files map[string]*stagedFile
+	steps []editStep
+}
+
+func (p *editPlan) file(uri protocol.DocumentURI) (string, *stagedFile, error) {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return "", nil, err
+	}
+	if file, ok := p.files[path]; ok {
+		return path, file, nil
+	}
+
+	file := &stagedFile{uri: uri}
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		file.onDisk = true
+		file.dir = true
+	case err == nil:
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", nil, err
+		}
+		file.onDisk = true
+		file.text = string(data)
+	case !os.IsNotExist(err):
+		return "", nil, err
+	}
+	if document, ok := p.lsp.documents.get(uri); ok {
+		file.open = true
+		file.text = document.Text
+		file.languageID = document.LanguageID
+		file.version = document.Version
+	}
+	p.files[path] = file
+	return path, file, nil
+}
+
+func (p *editPlan) stage(index int, change DocumentChange, annotations map[string]protocol.ChangeAnnotation) error {
+	switch {
+	case change.TextDocumentEdit != nil:
+		return p.stageTextEdit(index, change.TextDocumentEdit, annotations)
+	case change.CreateFile != nil:
+		return p.stageCreate(index, change.CreateFile)
+	case change.RenameFile != nil:
+		return p.stageRename(index, change.RenameFile)
+	case change.DeleteFile != nil:
+		return p.stageDelete(index, change.DeleteFile)
+	}
+	return errors.New("empty document change")
+}
+
+func (p *editPlan) stageTextEdit(index int, documentEdit *TextDocumentEdit, annotations map[string]protocol.ChangeAnnotation) error {
+	uri := documentEdit.TextDocument.URI
+	path, file, err := p.file(uri)
+	if err != nil {
+		return err
+	}
+	if !file.exists() || file.dir {
+		return fmt.Errorf("%s does not exist", uri)
+	}
+	if version := documentEdit.TextDocument.Version; version != nil && file.open && *version != file.version {
+		return fmt.Errorf("%s is at version %d, the edit is for version %d", uri, file.version, *version)
+	}
+
+	edits := make([]protocol.TextEdit, 0, len(documentEdit.Edits))
+	for _, edit := range documentEdit.Edits {
+		if edit.AnnotationID != "" {
+			annotation, ok := annotations[edit.AnnotationID]
+			if !ok {
+				return fmt.Errorf("%s: unknown change annotation %q", uri, edit.AnnotationID)
+			}
+			log.Infof("apply annotated edit. uri:%s, annotation:%s, needsConfirmation:%t", uri, annotation.Label, annotation.NeedsConfirmation)
+		}
+		edits = append(edits, edit.TextEdit)
+	}
+	changes := textEditsToChanges(edits)
+	for i := 1; i < len(changes); i++ {
+		if comparePosition(changes[i].Range.End, changes[i-1].Range.Start) > 0 {
+			return fmt.Errorf("%s: overlapping edits at %d:%d", uri, changes[i].Range.End.Line, changes[i].Range.End.Character)
+		}
+	}
+	oldText, newText := file.text, file.text
+	for _, change := range changes {
+		newText, err = applyContentChange(newText, change)
+		if err != nil {
+			return fmt.Errorf("%s: %w", uri, err)
+		}
+	}
+	file.text = newText
+
+	if file.open {
+		p.steps = append(p.steps, editStep{
+			index: index,
+			apply: func() error { return p.change(uri, changes) },
+			undo:  func() error { return p.change(uri, []protocol.TextDocumentContentChangeEvent{{Text: oldText}}) },
+		})
+		return nil
+	}
+	p.steps = append(p.steps, editStep{
+		index: index,
+		apply: func() error { return writeFile(path, newText) },
+		undo:  func() error { return writeFile(path, oldText) },
+	})
+	return nil
+}
+
+func (p *editPlan) stageCreate(index int, create *protocol.CreateFile) error {
+	path, file, err := p.file(create.URI)
+	if err != nil {
+		return err
+	}
+	if file.exists() {
+		if create.Options.IgnoreIfExists && !create.Options.Overwrite {
+			return nil
+		}
+		if !create.Options.Overwrite || file.dir {
+			return fmt.Errorf("%s already exists", create.URI)
+		}
+	}
+
+	existed, oldText := file.onDisk, file.text
+	var createdDir string
+	p.steps = append(p.steps, editStep{
+		index: index,
+		apply: func() error {
+			dir, err := createParents(path)
+			if err != nil {
+				return err
+			}
+			createdDir = dir
+			return writeFile(path, "")
+		},
+		undo: func() error {
+			if existed {
+				return writeFile(path, oldText)
+			}
+			return removeCreated(path, createdDir)
+		},
+	})
+	if file.open && oldText != "" {
+		uri := create.URI
+		p.steps = append(p.steps, editStep{
+			index: index,
+			apply: func() error { return p.change(uri, []protocol.TextDocumentContentChangeEvent{{Text: ""}}) },
+			undo:  func() error { return p.change(uri, []protocol.TextDocumentContentChangeEvent{{Text: oldText}}) },
+		})
+	}
+	file.onDisk = true
+	file.text = ""
+	return nil
+}
+
+func (p *editPlan) stageRename(index int, rename *protocol.RenameFile) error {
+	oldPath, oldFile, err := p.file(rename.OldURI)
+	if err != nil {
+		return err
+	}
+	newPath, newFile, err := p.file(rename.NewURI)
+	if err != nil {
+		return err
+	}
+	if !oldFile.exists() {
+		return fmt.Errorf("%s does not exist", rename.OldURI)
+	}
+	if newFile.exists() {
+		if rename.Options.IgnoreIfExists && !rename.Options.Overwrite {
+			return nil
+		}
+		if !rename.Options.Overwrite {
+			return fmt.Errorf("%s already exists", rename.NewURI)
+		}
+	}
+
+	source, target := *oldFile, *newFile
+	if source.onDisk {
+		var backup, createdDir string
+		p.steps = append(p.steps, editStep{
+			index: index,
+			apply: func() error {
+				if target.onDisk {
+					backup = backupPath(newPath)
+					err := os.Rename(newPath, backup)
+					if err != nil {
+						return err
+					}
+				}
+				dir, err := createParents(newPath)
+				if err != nil {
+					return err
+				}
+				createdDir = dir
+				return os.Rename(oldPath, newPath)
+			},
+			undo: func() error {
+				err := os.Rename(newPath, oldPath)
+				if err != nil {
+					return err
+				}
+				if backup != "" {
+					return os.Rename(backup, newPath)
+				}
+				if createdDir != "" {
+					return os.RemoveAll(createdDir)
+				}
+				return nil
+			},
+			finalize: func() error {
+				if backup == "" {
+					return nil
+				}
+				return os.RemoveAll(backup)
+			},
+		})
+	}
+	if target.open {
+		p.steps = append(p.steps, p.closeStep(index, target))
+	}
+	if source.open {
+		p.steps = append(p.steps, p.closeStep(index, source))
+		moved := source
+		moved.uri = rename.NewURI
+		p.steps = append(p.steps, p.openStep(index, moved))
+	}
+
+	*newFile = source
+	newFile.uri = rename.NewURI
+	*oldFile = stagedFile{uri: rename.OldURI}
+	return nil
+}
+
+func (p *editPlan) stageDelete(index int, deleteFile *protocol.DeleteFile) error {
+	path, file, err := p.file(deleteFile.URI)
+	if err != nil {
+		return err
+	}
+	if !file.exists() {
+		if deleteFile.Options.IgnoreIfNotExists {
+			return nil
+		}
+		return fmt.Errorf("%s does not exist", deleteFile.URI)
+	}
+	if file.dir && !deleteFile.Options.Recursive {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return fmt.Errorf("%s is a directory that is not empty", deleteFile.URI)
+		}
+	}
+
+	if file.onDisk {
+		backup := backupPath(path)
+		p.steps = append(p.steps, editStep{
+			index:    index,
+			apply:    func() error { return os.Rename(path, backup) },
+			undo:     func() error { return os.Rename(backup, path) },
+			finalize: func() error { return os.RemoveAll(backup) },
+		})
+	}
+	if file.open {
+		p.steps = append(p.steps, p.closeStep(index, *file))
+	}
+	*file = stagedFile{uri: deleteFile.URI}
+	return nil
+}
+
+func (p *editPlan) closeStep(index int, file stagedFile) editStep {
+	return editStep{
+		index: index,
+		apply: func() error { return p.close(file) },
+		undo:  func() error { return p.open(file) },
+	}
+}
+
+func (p *editPlan) openStep(index int, file stagedFile) editStep {
+	return editStep{
+		index: index,
+		apply: func() error { return p.open(file) },
+		undo:  func() error { return p.close(file) },
+	}
+}
+
+// change, open and close update an open document and tell the editors about it.
+func (p *editPlan) change(uri protocol.DocumentURI, changes []protocol.TextDocumentContentChangeEvent) error {
+	err := p.lsp.didChangeLocked(p.ctx, string(uri), changes)
+	if err != nil {
+		return err
+	}
+	document, _ := p.lsp.documents.get(uri)
+	p.lsp.documentEdits.publish(DocumentEditEvent{URI: uri, Version: document.Version, Changes: changes, Text: document.Text})
+	return nil
+}
+
+func (p *editPlan) open(file stagedFile) error {
+	err := p.lsp.didOpenLocked(p.ctx, string(file.uri), file.text, file.languageID)
+	if err != nil {
+		return err
+	}
+	document, _ := p.lsp.documents.get(file.uri)
+	p.lsp.documentEdits.publish(DocumentEditEvent{
+		URI:        file.uri,
+		Version:    document.Version,
+		LanguageID: file.languageID,
+		Changes:    []protocol.TextDocumentContentChangeEvent{{Text: file.text}},
+		Text:       file.text,
+	})
+	return nil
+}
+
+func (p *editPlan) close(file stagedFile) error {
+	err := p.lsp.didCloseLocked(p.ctx, string(file.uri))
+	if err != nil {
+		return err
+	}
+	p.lsp.documentEdits.publish(DocumentEditEvent{URI: file.uri, Closed: true})
+	return nil
+}
+
+func (p *editPlan) commit() error {
+	for i, step := range p.steps {
+		err := step.apply()
+		if err == nil {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			undoErr := p.steps[j].undo()
+			if undoErr != nil {
+				log.Errorf("rollback workspace edit change %d failed. err: %s", p.steps[j].index, undoErr)
+			}
+		}
+		return &WorkspaceEditError{FailedChange: step.index, Err: err}
+	}
+	for _, step := range p.steps {
+		if step.finalize == nil {
+			continue
+		}
+		err := step.finalize()
+		if err != nil {
+			log.Warnf("clean up workspace edit change %d failed. err: %s", step.index, err)
+		}
+	}
+	return nil
+}
+
+// writeFile keeps the permissions of an existing file.
+func writeFile(path, text string) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode()
+	}
+	return ioutil.WriteFile(path, []byte(text), mode)
+}
+
+// createParents creates the missing parent directories of path and returns the topmost
+// one it created, or "" if they all existed.
+func createParents(path string) (string, error) {
+	dir := filepath.Dir(path)
+	created := ""
+	for current := dir; ; current = filepath.Dir(current) {
+		if _, err := os.Stat(current); err == nil {
+			break
+		}
+		created = current
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+	if created == "" {
+		return "", nil
+	}
+	return created, os.MkdirAll(dir, 0755)
+}
+
+func removeCreated(path, createdDir string) error {
+	if createdDir != "" {
+		return os.RemoveAll(createdDir)
+	}
+	return os.Remove(path)
+}
+
+func backupPath(path string) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.backup-%d", filepath.Base(path), time.Now().UnixNano()))
+}
+
+// textEditsToChanges orders text edits, whose ranges all refer to the original
+// document, so that applying them one after another gives the same result. Edits that
+// start at the same position, inserts and at most one replacement, become one change with
+// their texts in the original order. Overlapping edits stay apart for the caller to reject.
+func textEditsToChanges(edits []protocol.TextEdit) []protocol.TextDocumentContentChangeEvent {
+	sorted := make([]protocol.TextEdit, len(edits))
+	copy(sorted, edits)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return comparePosition(sorted[i].Range.Start, sorted[j].Range.Start) > 0
+	})
+
+	changes := make([]protocol.TextDocumentContentChangeEvent, 0, len(sorted))
+	for _, edit := range sorted {
+		editRange := edit.Range
+		empty := comparePosition(editRange.Start, editRange.End) == 0
+		if n := len(changes); n > 0 {
+			last := &changes[n-1]
+			lastEmpty := comparePosition(last.Range.Start, last.Range.End) == 0
+			if comparePosition(last.Range.Start, editRange.Start) == 0 && (empty || lastEmpty) {
+				if !empty {
+					last.Range.End = editRange.End
+				}
+				last.Text += edit.NewText
+				continue
+			}
+		}
+		changes = append(changes, protocol.TextDocumentContentChangeEvent{Range: &editRange, Text: edit.NewText})
+	}
+	return changes
+}
+
+func comparePosition(a, b protocol.Position) int {
+	switch {
+	case a.Line < b.Line:
+		return -1
+	case a.Line > b.Line:
+		return 1
+	case a.Character < b.Character:
+		return -1
+	case a.Character > b.Character:
+		return 1
+	}
+	return 0
+}
+
+func uriToPath(uri protocol.DocumentURI) (string, error) {
+	u, err := url.Parse(string(uri))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported uri scheme %q in %s", u.Scheme, uri)
+	}
+	path := u.Path
+	// file:///d:/test/hello.go
+	if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path), nil
+}
+
+// pathToURI escapes the path, file names may contain spaces or '%'.
+func pathToURI(path string) protocol.DocumentURI {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return protocol.DocumentURI((&url.URL{Scheme: "file", Path: path}).String())
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"lsp/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func textEdit(startLine, startCharacter, endLine, endCharacter uint32, text string) protocol.TextEdit {
+	return protocol.TextEdit{
+		Range: protocol.Range{
+			Start: protocol.Position{Line: startLine, Character: startCharacter},
+			End:   protocol.Position{Line: endLine, Character: endCharacter},
+		},
+		NewText: text,
+	}
+}
+
+func newTestLanguageServer() *LanguageServer {
+	return &LanguageServer{
+		documents:      newDocumentStore(),
+		semanticTokens: newSemanticTokensCache(),
+		codeLenses:     newCodeLensCache(),
+		documentEdits:  newSubscribers("document edit", false),
+	}
+}
+
+func writeTestFile(t *testing.T, dir, name, text string) (string, protocol.DocumentURI) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(text), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, pathURI(path)
+}
+
+func pathURI(path string) protocol.DocumentURI {
+	return protocol.DocumentURI("file://" + filepath.ToSlash(path))
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTextEditsToChanges(t *testing.T) {
+	text := "package main\n\nfunc main() {\n}\n"
+	edits := []protocol.TextEdit{
+		textEdit(0, 8, 0, 12, "demo"),
+		textEdit(1, 0, 1, 0, "import \"fmt\"\n"),
+		textEdit(3, 0, 3, 0, "\tfmt.Println()\n"),
+		textEdit(1, 0, 1, 0, "import \"os\"\n"),
+		textEdit(2, 5, 2, 9, "run"),
+	}
+	changes := textEditsToChanges(edits)
+
+	for i := 1; i < len(changes); i++ {
+		if comparePosition(changes[i].Range.Start, changes[i-1].Range.Start) > 0 {
+			t.Fatalf("change %d starts after change %d", i, i-1)
+		}
+	}
+	for _, change := range changes {
+		var err error
+		text, err = applyContentChange(text, change)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "package demo\nimport \"fmt\"\nimport \"os\"\n\nfunc run() {\n\tfmt.Println()\n}\n"
+	if text != want {
+		t.Fatalf("text = %q, want %q", text, want)
+	}
+}
+
+func TestApplyWorkspaceEditOverlap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workspace-edit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path, uri := writeTestFile(t, dir, "main.go", "package main\n")
+
+	lsp := newTestLanguageServer()
+	edit := WorkspaceEdit{Changes: map[protocol.DocumentURI][]protocol.TextEdit{
+		uri: {textEdit(0, 0, 0, 7, "// package"), textEdit(0, 5, 0, 12, "x")},
+	}}
+	err = lsp.ApplyWorkspaceEdit(context.Background(), edit)
+	var editErr *WorkspaceEditError
+	if !errors.As(err, &editErr) || editErr.FailedChange != 0 {
+		t.Fatalf("ApplyWorkspaceEdit = %v, want a WorkspaceEditError for change 0", err)
+	}
+	if text := readTestFile(t, path); text != "package main\n" {
+		t.Fatalf("file changed to %q", text)
+	}
+}
+
+func TestApplyWorkspaceEditTouchingEdits(t *testing.T) {
+	tests := []struct {
+		name  string
+		edits []protocol.TextEdit
+		want  string
+	}{
+		{"insert at the start of a replacement", []protocol.TextEdit{textEdit(0, 4, 0, 8, "ABCD"), textEdit(0, 4, 0, 4, "<")}, "0123ABCD<89"},
+		{"insert before a replacement", []protocol.TextEdit{textEdit(0, 4, 0, 4, "<"), textEdit(0, 4, 0, 8, "ABCD")}, "0123<ABCD89"},
+		{"insert at the end of a replacement", []protocol.TextEdit{textEdit(0, 8, 0, 8, ">"), textEdit(0, 4, 0, 8, "ABCD")}, "0123ABCD>89"},
+		{"adjacent replacements", []protocol.TextEdit{textEdit(0, 0, 0, 2, "ab"), textEdit(0, 2, 0, 4, "cd")}, "abcd456789"},
+		{"overlapping replacements", []protocol.TextEdit{textEdit(0, 0, 0, 3, "abc"), textEdit(0, 2, 0, 4, "cd")}, ""},
+		{"replacements with the same start", []protocol.TextEdit{textEdit(0, 2, 0, 3, "x"), textEdit(0, 2, 0, 4, "y")}, ""},
+		{"insert inside a replacement", []protocol.TextEdit{textEdit(0, 2, 0, 6, "x"), textEdit(0, 4, 0, 4, "y")}, ""},
+	}
+	for _, test := range tests {
+		lsp := newTestLanguageServer()
+		uri := protocol.DocumentURI("file:///workspace/digits.txt")
+		_, err := lsp.documents.open(uri, "plaintext", "0123456789")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = lsp.ApplyWorkspaceEdit(context.Background(), WorkspaceEdit{Changes: map[protocol.DocumentURI][]protocol.TextEdit{uri: test.edits}})
+		document, _ := lsp.documents.get(uri)
+		if test.want == "" {
+			if err == nil || document.Text != "0123456789" {
+				t.Errorf("%s: ApplyWorkspaceEdit = %v with text %q, want an error", test.name, err, document.Text)
+			}
+			continue
+		}
+		if err != nil || document.Text != test.want {
+			t.Errorf("%s: ApplyWorkspaceEdit = %v with text %q, want %q", test.name, err, document.Text, test.want)
+		}
+	}
+}
+
+func TestApplyWorkspaceEditVersionMismatch(t *testing.T) {
+	lsp := newTestLanguageServer()
+	uri := protocol.DocumentURI("file:///workspace/main.go")
+	_, err := lsp.documents.open(uri, "go", "package main\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version := int32(2)
+	edit := WorkspaceEdit{DocumentChanges: []DocumentChange{{TextDocumentEdit: &TextDocumentEdit{
+		TextDocument: VersionedTextDocument{URI: uri, Version: &version},
+		Edits:        []protocol.AnnotatedTextEdit{{TextEdit: textEdit(0, 8, 0, 12, "demo")}},
+	}}}}
+	err = lsp.ApplyWorkspaceEdit(context.Background(), edit)
+	var editErr *WorkspaceEditError
+	if !errors.As(err, &editErr) {
+		t.Fatalf("ApplyWorkspaceEdit = %v, want a WorkspaceEditError", err)
+	}
+	document, _ := lsp.documents.get(uri)
+	if document.Text != "package main\n" || document.Version != 1 {
+		t.Fatalf("document changed to version %d %q", document.Version, document.Text)
+	}
+}
+
+func TestEditPlanRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workspace-edit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	editedPath, editedURI := writeTestFile(t, dir, "edited.go", "package main\n")
+	renamedPath, renamedURI := writeTestFile(t, dir, "renamed.go", "package renamed\n")
+	createdPath := filepath.Join(dir, "sub", "created.go")
+	targetPath := filepath.Join(dir, "target.go")
+
+	lsp := newTestLanguageServer()
+	openURI := pathURI(filepath.Join(dir, "open.go"))
+	_, err = lsp.documents.open(openURI, "go", "package open\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := []DocumentChange{
+		{TextDocumentEdit: &TextDocumentEdit{
+			TextDocument: VersionedTextDocument{URI: editedURI},
+			Edits:        []protocol.AnnotatedTextEdit{{TextEdit: textEdit(0, 8, 0, 12, "demo")}},
+		}},
+		{CreateFile: &protocol.CreateFile{Kind: "create", URI: pathURI(createdPath)}},
+		{RenameFile: &protocol.RenameFile{Kind: "rename", OldURI: renamedURI, NewURI: pathURI(targetPath)}},
+		{TextDocumentEdit: &TextDocumentEdit{
+			TextDocument: VersionedTextDocument{URI: openURI},
+			Edits:        []protocol.AnnotatedTextEdit{{TextEdit: textEdit(0, 8, 0, 12, "closed")}},
+		}},
+	}
+	edits, cancelEdits := lsp.SubscribeDocumentEdits()
+	defer cancelEdits()
+	lsp.documentMutex.Lock()
+	plan := &editPlan{ctx: context.Background(), lsp: lsp, files: make(map[string]*stagedFile)}
+	for i, change := range changes {
+		err = plan.stage(i, change, nil)
+		if err != nil {
+			t.Fatalf("stage change %d: %s", i, err)
+		}
+	}
+	failure := errors.New("failure")
+	plan.steps = append(plan.steps, editStep{
+		index: len(changes),
+		apply: func() error { return failure },
+		undo:  func() error { return nil },
+	})
+	err = plan.commit()
+	lsp.documentMutex.Unlock()
+
+	var editErr *WorkspaceEditError
+	if !errors.As(err, &editErr) || editErr.FailedChange != len(changes) || !errors.Is(err, failure) {
+		t.Fatalf("commit = %v, want a WorkspaceEditError for change %d", err, len(changes))
+	}
+	if text := readTestFile(t, editedPath); text != "package main\n" {
+		t.Errorf("edited file is %q after rollback", text)
+	}
+	if text := readTestFile(t, renamedPath); text != "package renamed\n" {
+		t.Errorf("renamed file is %q after rollback", text)
+	}
+	for _, path := range []string{targetPath, createdPath, filepath.Dir(createdPath)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s exists after rollback", path)
+		}
+	}
+	document, _ := lsp.documents.get(openURI)
+	if document.Text != "package open\n" {
+		t.Errorf("open document is %q after rollback", document.Text)
+	}
+
+	// The editors see the edit and its rollback.
+	events := []DocumentEditEvent{<-edits, <-edits}
+	if events[0].URI != openURI || events[0].Changes[0].Text != "closed" || events[0].Version != 2 || events[0].Text != "package closed\n" {
+		t.Errorf("edit event = %+v", events[0])
+	}
+	if events[1].URI != openURI || events[1].Changes[0].Range != nil || events[1].Changes[0].Text != "package open\n" {
+		t.Errorf("rollback event = %+v", events[1])
+	}
+}